@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"golang.org/x/xerrors"
+)
+
+// Users of cloud registries
+const (
+	// ECRUser is the user of the passwords issued by ecr:GetAuthorizationToken
+	ECRUser = "AWS"
+	// GCRAccessTokenUser is the user of OAuth2 access tokens for GCR and Artifact Registry
+	GCRAccessTokenUser = "oauth2accesstoken"
+	// GCRJSONKeyUser is the user of service account JSON keys for GCR and Artifact Registry
+	GCRJSONKeyUser = "_json_key"
+	// ACRRefreshTokenUser is the user of ACR refresh tokens
+	ACRRefreshTokenUser = "00000000-0000-0000-0000-000000000000"
+)
+
+const (
+	acrTokenPath    = "/oauth2/token"
+	acrExchangePath = "/oauth2/exchange"
+)
+
+// ref. https://learn.microsoft.com/en-us/azure/container-registry/container-registry-authentication
+type exchangeResponse struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ECR returns Auth emulating Amazon ECR, which authenticates the user "AWS" with a password valid until expiresAt.
+// ECR challenges with Basic, so use it with registry.Option.BasicAuth.
+func ECR(password string, expiresAt time.Time) Auth {
+	users := NewUsers()
+	users.Add(ECRUser, password, nil)
+	_ = users.SetExpiry(ECRUser, expiresAt)
+
+	return Auth{
+		Users:   users,
+		Service: "ecr.amazonaws.com",
+	}
+}
+
+// ECRAuthorizationToken returns the authorization token for the password as ecr:GetAuthorizationToken returns
+func ECRAuthorizationToken(password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(ECRUser + ":" + password))
+}
+
+// GCR returns Auth emulating Google Container Registry and Artifact Registry,
+// which issue tokens to "oauth2accesstoken" with an access token or "_json_key" with a service account key.
+// Empty credentials are not accepted.
+func GCR(accessToken, jsonKey string) Auth {
+	users := NewUsers()
+	if accessToken != "" {
+		users.Add(GCRAccessTokenUser, accessToken, nil)
+	}
+	if jsonKey != "" {
+		users.Add(GCRJSONKeyUser, jsonKey, nil)
+	}
+
+	return Auth{
+		Users:   users,
+		Secret:  newSecret(),
+		Service: "gcr.io",
+	}
+}
+
+// ACR returns Auth emulating Azure Container Registry.
+// Clients exchange the Microsoft Entra access token for a refresh token at /oauth2/exchange,
+// then get tokens at /oauth2/token with the refresh token as the password of "00000000-0000-0000-0000-000000000000".
+func ACR(aadAccessToken string) Auth {
+	return Auth{
+		Secret:        newSecret(),
+		tokenPath:     acrTokenPath,
+		exchangeToken: aadAccessToken,
+	}
+}
+
+// exchangeHandler exchanges the Microsoft Entra access token for an ACR refresh token
+func (a *authRouter) exchangeHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return errdefs.InvalidParameter(err)
+	}
+
+	if grantType := r.PostForm.Get("grant_type"); grantType != "access_token" {
+		return errdefs.InvalidParameter(xerrors.Errorf("unsupported grant_type: %q", grantType))
+	}
+	if r.PostForm.Get("access_token") != a.auth.exchangeToken {
+		return errdefs.Unauthorized(xerrors.New("invalid access token"))
+	}
+
+	refreshToken, err := a.signRefreshToken(ACRRefreshTokenUser, 0, time.Now())
+	if err != nil {
+		return errdefs.Unavailable(err)
+	}
+
+	b, _ := json.Marshal(exchangeResponse{RefreshToken: refreshToken})
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(b); err != nil {
+		return errdefs.Unavailable(err)
+	}
+	return nil
+}
+
+func newSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("auth: %s", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base32"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/xerrors"
+)
+
+// Signing methods of tokens
+const (
+	SigningMethodHS256 = "HS256"
+	SigningMethodRS256 = "RS256"
+	SigningMethodES256 = "ES256"
+)
+
+// keys holds the keys to sign and verify tokens
+type keys struct {
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+
+	kid string
+	x5c []string // base64 DER encoded certificate chain
+	jwk *jsonWebKey
+}
+
+// ref. https://datatracker.ietf.org/doc/html/rfc7517
+type jsonWebKey struct {
+	Kty string   `json:"kty"`
+	Use string   `json:"use"`
+	Alg string   `json:"alg"`
+	Kid string   `json:"kid"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	X5c []string `json:"x5c,omitempty"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// GenerateSigningKey generates a key pair for the asymmetric signing method, RS256 or ES256.
+func GenerateSigningKey(method string) (crypto.Signer, error) {
+	switch method {
+	case SigningMethodRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case SigningMethodES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, xerrors.Errorf("unsupported signing method: %s", method)
+	}
+}
+
+// newKeys returns the keys configured in Auth. A key pair and a self-signed certificate are generated if not supplied.
+func newKeys(a Auth) (keys, error) {
+	if a.SigningMethod == "" || a.SigningMethod == SigningMethodHS256 {
+		return keys{
+			method:    jwt.SigningMethodHS256,
+			signKey:   []byte(a.Secret),
+			verifyKey: []byte(a.Secret),
+		}, nil
+	}
+
+	method := jwt.GetSigningMethod(a.SigningMethod)
+	if method == nil {
+		return keys{}, xerrors.Errorf("unsupported signing method: %s", a.SigningMethod)
+	}
+
+	key := a.SigningKey
+	if key == nil {
+		var err error
+		if key, err = GenerateSigningKey(a.SigningMethod); err != nil {
+			return keys{}, err
+		}
+	} else if err := checkSigningKey(a.SigningMethod, key); err != nil {
+		return keys{}, err
+	}
+
+	certs := a.Certificates
+	if certs == nil {
+		cert, err := selfSignedCertificate(key)
+		if err != nil {
+			return keys{}, xerrors.Errorf("failed to generate a certificate: %w", err)
+		}
+		certs = []*x509.Certificate{cert}
+	}
+
+	kid, err := keyID(key.Public())
+	if err != nil {
+		return keys{}, err
+	}
+
+	var x5c []string
+	for _, cert := range certs {
+		x5c = append(x5c, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+
+	jwk := &jsonWebKey{
+		Use: "sig",
+		Alg: a.SigningMethod,
+		Kid: kid,
+		X5c: x5c,
+	}
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	default:
+		return keys{}, xerrors.Errorf("unsupported key type: %T", pub)
+	}
+
+	return keys{
+		method:    method,
+		signKey:   key,
+		verifyKey: key.Public(),
+		kid:       kid,
+		x5c:       x5c,
+		jwk:       jwk,
+	}, nil
+}
+
+// checkSigningKey reports an error if the key can't sign tokens with the method
+func checkSigningKey(method string, key crypto.Signer) error {
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		if method == SigningMethodRS256 {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if method == SigningMethodES256 && pub.Curve == elliptic.P256() {
+			return nil
+		}
+	}
+	return xerrors.Errorf("the signing key (%T) doesn't match the signing method: %s", key, method)
+}
+
+// keyID returns the key ID in the format of libtrust, which distribution uses.
+// ref. https://github.com/docker/libtrust/blob/aabc10ec26b754e797f9028f4589c5b7bd90dc20/util.go#L194
+func keyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", xerrors.Errorf("failed to marshal the public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	s := strings.TrimRight(base32.StdEncoding.EncodeToString(sum[:30]), "=")
+
+	var groups []string
+	for i := 0; i < len(s); i += 4 {
+		groups = append(groups, s[i:i+4])
+	}
+	return strings.Join(groups, ":"), nil
+}
+
+func selfSignedCertificate(key crypto.Signer) (*x509.Certificate, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: issuer},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Actions which can be granted on a repository
+const (
+	ActionPull   = "pull"
+	ActionPush   = "push"
+	ActionDelete = "delete"
+	ActionAll    = "*"
+)
+
+const (
+	typeRepository = "repository"
+	typeRegistry   = "registry"
+	catalog        = "catalog"
+)
+
+type claimsKey struct{}
+
+// ref. https://distribution.github.io/distribution/spec/auth/jwt/
+type ResourceActions struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// String returns the scope in the format of the token request, e.g. "repository:library/alpine:pull,push"
+func (ra ResourceActions) String() string {
+	return fmt.Sprintf("%s:%s:%s", ra.Type, ra.Name, strings.Join(ra.Actions, ","))
+}
+
+// parseScopes parses the scope parameters of the token request.
+// Names containing pattern characters are dropped, since repository names can never contain them
+// and the names in the token are matched as patterns.
+// ref. https://distribution.github.io/distribution/spec/auth/scope/
+func parseScopes(scopes []string) []ResourceActions {
+	var access []ResourceActions
+	for _, scope := range scopes {
+		for _, s := range strings.Fields(scope) {
+			// The name may contain a colon, e.g. "repository:localhost:5000/foo:pull"
+			typ, rest, ok := strings.Cut(s, ":")
+			i := strings.LastIndex(rest, ":")
+			if !ok || i < 0 || strings.ContainsAny(rest[:i], `*?[\`) {
+				continue
+			}
+			access = append(access, ResourceActions{
+				Type:    typ,
+				Name:    rest[:i],
+				Actions: strings.Split(rest[i+1:], ","),
+			})
+		}
+	}
+	return access
+}
+
+// requiredScope returns the scope required to serve the request.
+// It reports false if the request doesn't need any scope, e.g. the API version check.
+// The repository is taken from the {name} variable of the matched route
+// so that it is always the repository the handler serves.
+func requiredScope(r *http.Request) (ResourceActions, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ResourceActions{}, false
+	}
+
+	if tmpl, err := route.GetPathTemplate(); err == nil && strings.HasSuffix(tmpl, "/_catalog") {
+		return ResourceActions{
+			Type:    typeRegistry,
+			Name:    catalog,
+			Actions: []string{ActionAll},
+		}, true
+	}
+
+	name, ok := mux.Vars(r)["name"]
+	if !ok {
+		return ResourceActions{}, false
+	}
+
+	action := ActionPull
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		action = ActionPush
+	case http.MethodDelete:
+		action = ActionDelete
+	}
+
+	return ResourceActions{
+		Type:    typeRepository,
+		Name:    name,
+		Actions: []string{action},
+	}, true
+}
+
+// versioned reports whether the request is routed with the API version prefix such as "/v2".
+// The routes are also registered without the prefix, but registry clients never use them.
+func versioned(r *http.Request) bool {
+	return mux.Vars(r)["version"] != ""
+}
+
+// restricted reports whether tokens of the user are authorized against the permissions
+func (a *authRouter) restricted(user string) bool {
+	return a.permissions(user) != nil
+}
+
+// permissions returns the permissions of the user in Users, or Auth.Permissions if not set
+func (a *authRouter) permissions(user string) map[string][]string {
+	if a.auth.Users != nil && user != a.auth.User {
+		if _, permissions, ok := a.auth.Users.lookup(user); ok && permissions != nil {
+			return permissions
+		}
+	}
+	return a.auth.Permissions
+}
+
+// permittedActions returns the actions the user is allowed on the repository.
+// The user is empty for anonymous tokens.
+func (a *authRouter) permittedActions(user, repository string) []string {
+	var actions []string
+	for _, pattern := range a.auth.PublicRepositories {
+		if matched, _ := path.Match(pattern, repository); matched {
+			actions = append(actions, ActionPull)
+		}
+	}
+	if user == "" {
+		return actions
+	}
+	for pattern, acts := range a.permissions(user) {
+		if matched, _ := path.Match(pattern, repository); matched {
+			actions = append(actions, acts...)
+		}
+	}
+	return actions
+}
+
+// grant returns the subset of the requested access permitted to the user
+func (a *authRouter) grant(user string, requested []ResourceActions) []ResourceActions {
+	var granted []ResourceActions
+	for _, ra := range requested {
+		switch {
+		case ra.Type == typeRepository:
+			permitted := a.permittedActions(user, ra.Name)
+
+			var actions []string
+			for _, action := range ra.Actions {
+				if slices.Contains(permitted, action) || slices.Contains(permitted, ActionAll) {
+					actions = append(actions, action)
+				}
+			}
+			if len(actions) > 0 {
+				granted = append(granted, ResourceActions{
+					Type:    ra.Type,
+					Name:    ra.Name,
+					Actions: actions,
+				})
+			}
+		case ra.Type == typeRegistry && ra.Name == catalog && user != "":
+			granted = append(granted, ra)
+			// The catalog is filtered by the repositories the token grants pull access to
+			granted = append(granted, a.pullable(user)...)
+		}
+	}
+	return granted
+}
+
+// pullable returns the access to all the repositories the user can pull
+func (a *authRouter) pullable(user string) []ResourceActions {
+	patterns := slices.Clone(a.auth.PublicRepositories)
+	for pattern, actions := range a.permissions(user) {
+		if slices.Contains(actions, ActionPull) || slices.Contains(actions, ActionAll) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+
+	var access []ResourceActions
+	for _, pattern := range slices.Compact(patterns) {
+		access = append(access, ResourceActions{
+			Type:    typeRepository,
+			Name:    pattern,
+			Actions: []string{ActionPull},
+		})
+	}
+	return access
+}
+
+// allows reports whether the access grants the action on the resource
+func allows(access []ResourceActions, typ, name, action string) bool {
+	for _, ra := range access {
+		if ra.Type != typ {
+			continue
+		}
+		if matched, _ := path.Match(ra.Name, name); !matched {
+			continue
+		}
+		if slices.Contains(ra.Actions, action) || slices.Contains(ra.Actions, ActionAll) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether the bearer token of the request being served grants the action on the repository.
+// It always returns true when the registry doesn't require authentication or authorization.
+func Allowed(ctx context.Context, repository, action string) bool {
+	c, ok := ctx.Value(claimsKey{}).(claims)
+	if !ok {
+		return true
+	}
+	return allows(c.Access, typeRepository, repository, action)
+}
@@ -0,0 +1,16 @@
+package auth
+
+import (
+	"net/http/httptest"
+
+	"github.com/docker/docker/api/server/router"
+
+	"github.com/aquasecurity/testdocker/server"
+)
+
+// NewTokenServer starts a token server separate from registries, like auth.docker.io for registry-1.docker.io.
+// Registries trusting the server must be configured with the same keys and Realm pointing to "<URL>/token".
+func NewTokenServer(auth Auth) *httptest.Server {
+	m := server.CreateMux([]router.Router{NewRouter(auth)})
+	return httptest.NewServer(m)
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/xerrors"
+)
+
+// Users is a user store which is safe to update while servers are running
+type Users struct {
+	mu    sync.RWMutex
+	users map[string]*user
+}
+
+type user struct {
+	hash        []byte // SHA-256 digest of the password, or bcrypt hash if loaded from htpasswd
+	bcrypt      bool
+	expiresAt   time.Time // the password is rejected after the time if set
+	generation  int64     // incremented when the tokens of the user are revoked
+	permissions map[string][]string
+}
+
+// NewUsers returns an empty user store
+func NewUsers() *Users {
+	return &Users{
+		users: map[string]*user{},
+	}
+}
+
+// LoadHtpasswd returns a user store filled from the htpasswd file. Only bcrypt passwords are supported.
+func LoadHtpasswd(filePath string) (*Users, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	return ParseHtpasswd(f)
+}
+
+// ParseHtpasswd returns a user store filled from the content of a htpasswd file. Only bcrypt passwords are supported.
+// ref. https://httpd.apache.org/docs/2.4/misc/password_encryptions.html
+func ParseHtpasswd(r io.Reader) (*Users, error) {
+	u := NewUsers()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, xerrors.Errorf("invalid htpasswd entry: %s", line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, xerrors.Errorf("unsupported password hash of %s: %w", name, err)
+		}
+		u.users[name] = &user{
+			hash:   []byte(hash),
+			bcrypt: true,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to read htpasswd: %w", err)
+	}
+
+	return u, nil
+}
+
+// Add adds the user, or replaces the password and permissions if the user exists.
+// Permissions are the same as Auth.Permissions. If nil, Auth.Permissions is applied.
+func (u *Users) Add(name, password string, permissions map[string][]string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if usr, ok := u.users[name]; ok {
+		usr.setPassword(password)
+		usr.permissions = permissions
+		return
+	}
+	usr := &user{permissions: permissions}
+	usr.setPassword(password)
+	u.users[name] = usr
+}
+
+// Remove removes the user. Tokens issued to the user are rejected.
+func (u *Users) Remove(name string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	delete(u.users, name)
+}
+
+// SetPassword changes the password of the user. Tokens issued before the change stay valid until RevokeTokens is called.
+func (u *Users) SetPassword(name, password string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	usr, ok := u.users[name]
+	if !ok {
+		return xerrors.Errorf("unknown user: %s", name)
+	}
+	usr.setPassword(password)
+	return nil
+}
+
+// SetExpiry makes the password of the user rejected after expiresAt, like the temporary passwords of cloud registries.
+// Tokens issued before the expiry stay valid until they expire.
+func (u *Users) SetExpiry(name string, expiresAt time.Time) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	usr, ok := u.users[name]
+	if !ok {
+		return xerrors.Errorf("unknown user: %s", name)
+	}
+	usr.expiresAt = expiresAt
+	return nil
+}
+
+// RevokeTokens rejects the bearer and refresh tokens issued to the user so far
+func (u *Users) RevokeTokens(name string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	usr, ok := u.users[name]
+	if !ok {
+		return xerrors.Errorf("unknown user: %s", name)
+	}
+	usr.generation++
+	return nil
+}
+
+// authenticate verifies the password and returns the current generation of the user
+func (u *Users) authenticate(name, password string) (int64, bool) {
+	u.mu.RLock()
+	p, ok := u.users[name]
+	if !ok {
+		u.mu.RUnlock()
+		return 0, false
+	}
+	usr := *p
+	u.mu.RUnlock()
+
+	if !usr.expiresAt.IsZero() && time.Now().After(usr.expiresAt) {
+		return 0, false
+	}
+	if usr.bcrypt {
+		if bcrypt.CompareHashAndPassword(usr.hash, []byte(password)) != nil {
+			return 0, false
+		}
+		return usr.generation, true
+	}
+
+	// bcrypt can't hash passwords longer than 72 bytes such as access tokens
+	sum := sha256.Sum256([]byte(password))
+	if subtle.ConstantTimeCompare(usr.hash, sum[:]) != 1 {
+		return 0, false
+	}
+	return usr.generation, true
+}
+
+func (usr *user) setPassword(password string) {
+	sum := sha256.Sum256([]byte(password))
+	usr.hash = sum[:]
+	usr.bcrypt = false
+}
+
+// lookup returns the current generation and the permissions of the user
+func (u *Users) lookup(name string) (int64, map[string][]string, bool) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	usr, ok := u.users[name]
+	if !ok {
+		return 0, nil, false
+	}
+	return usr.generation, usr.permissions, true
+}
@@ -0,0 +1,357 @@
+package image
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/server/httputils"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/errdefs"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/xerrors"
+
+	"github.com/aquasecurity/testdocker/tarfile"
+)
+
+var acceptedImageFilters = map[string]bool{
+	"before":    true,
+	"dangling":  true,
+	"label":     true,
+	"reference": true,
+	"since":     true,
+}
+
+// imageInfo is an image in the engine to be listed
+type imageInfo struct {
+	img        v1.Image
+	config     *v1.ConfigFile
+	id         string
+	repoTags   []string
+	layerSizes map[v1.Hash]int64 // uncompressed sizes keyed by diff ID
+}
+
+func (i imageInfo) size() int64 {
+	var size int64
+	for _, s := range i.layerSizes {
+		size += s
+	}
+	return size
+}
+
+// ref. https://github.com/moby/moby/blob/4f0d95fa6ee7f865597c03b9e63702cdcb0f7067/api/server/router/image/image_routes.go#L421
+func (s *imageRouter) getImagesJSON(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := httputils.ParseForm(r); err != nil {
+		return errdefs.InvalidParameter(err)
+	}
+
+	imageFilters, err := filters.FromJSON(r.Form.Get("filters"))
+	if err != nil {
+		return err
+	}
+	if err = imageFilters.Validate(acceptedImageFilters); err != nil {
+		return err
+	}
+
+	// The routes without the version prefix behave as the latest API
+	version := vars["version"]
+	atLeast := func(v string) bool {
+		return version == "" || versions.GreaterThanOrEqualTo(version, v)
+	}
+	sharedSize := atLeast("1.42") && httputils.BoolValue(r, "shared-size")
+	withManifests := atLeast("1.47") && httputils.BoolValue(r, "manifests")
+
+	// All the images are top-level, so "all" and "digests" don't change the result
+	infos, err := s.imageInfos()
+	if err != nil {
+		return err
+	}
+
+	infos, err = filterImages(infos, imageFilters)
+	if err != nil {
+		return err
+	}
+
+	// Count the images sharing each layer
+	layerRefs := map[v1.Hash]int{}
+	for _, info := range infos {
+		for diffID := range info.layerSizes {
+			layerRefs[diffID]++
+		}
+	}
+
+	summaries := []image.Summary{}
+	for _, info := range infos {
+		summary := image.Summary{
+			Containers:  -1, // not supported
+			Created:     info.config.Created.Unix(),
+			ID:          info.id,
+			Labels:      info.config.Config.Labels,
+			ParentID:    "", // not supported
+			RepoDigests: []string{},
+			RepoTags:    info.repoTags,
+			SharedSize:  -1,
+			Size:        info.size(),
+		}
+
+		if sharedSize {
+			summary.SharedSize = 0
+			for diffID, size := range info.layerSizes {
+				if layerRefs[diffID] > 1 {
+					summary.SharedSize += size
+				}
+			}
+		}
+
+		if !atLeast("1.43") && len(summary.RepoTags) == 0 {
+			summary.RepoTags = []string{"<none>:<none>"}
+			summary.RepoDigests = []string{"<none>@<none>"}
+		}
+		if !atLeast("1.44") {
+			summary.VirtualSize = summary.Size
+		}
+
+		desc, err := manifestDescriptor(info.img)
+		if err != nil {
+			return errdefs.Unavailable(err)
+		}
+		if atLeast("1.48") {
+			summary.Descriptor = &desc
+		}
+		if withManifests {
+			summary.Manifests = []image.ManifestSummary{manifestSummary(info, desc)}
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return httputils.WriteJSON(w, http.StatusOK, summaries)
+}
+
+// imageInfos loads all the images in the engine. Tarballs of the same image are merged.
+func (s *imageRouter) imageInfos() ([]imageInfo, error) {
+	entries, err := s.store.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[string]*imageInfo{}
+	for _, entry := range entries {
+		info, err := loadImageInfo(entry, s.store.repoTags(entry))
+		if err != nil {
+			return nil, err
+		}
+
+		if existing, ok := byID[info.id]; ok {
+			existing.repoTags = append(existing.repoTags, info.repoTags...)
+			continue
+		}
+		byID[info.id] = &info
+	}
+
+	var infos []imageInfo
+	for _, info := range byID {
+		sort.Strings(info.repoTags)
+		info.repoTags = slices.Compact(info.repoTags)
+		infos = append(infos, *info)
+	}
+
+	// Newer images first as docker does
+	sort.Slice(infos, func(i, j int) bool {
+		if ti, tj := infos[i].config.Created.Time, infos[j].config.Created.Time; !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return infos[i].id < infos[j].id
+	})
+	return infos, nil
+}
+
+func loadImageInfo(entry imageEntry, repoTags []string) (imageInfo, error) {
+	img := entry.img
+	config, err := img.ConfigFile()
+	if err != nil {
+		return imageInfo{}, errdefs.Unavailable(err)
+	}
+
+	configName, err := img.ConfigName()
+	if err != nil {
+		return imageInfo{}, errdefs.Unavailable(err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return imageInfo{}, errdefs.Unavailable(err)
+	}
+
+	layerSizes := map[v1.Hash]int64{}
+	for _, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return imageInfo{}, errdefs.Unavailable(err)
+		}
+
+		reader, err := layer.Uncompressed()
+		if err != nil {
+			return imageInfo{}, errdefs.Unavailable(err)
+		}
+		size, err := tarfile.UncompressedLayerSize(reader)
+		reader.Close()
+		if err != nil {
+			return imageInfo{}, errdefs.Unavailable(xerrors.Errorf("failed calculating uncompressed size (%s): %w", diffID, err))
+		}
+		layerSizes[diffID] = size
+	}
+
+	return imageInfo{
+		img:        img,
+		config:     config,
+		id:         configName.String(),
+		repoTags:   repoTags,
+		layerSizes: layerSizes,
+	}, nil
+}
+
+// filterImages returns the images matching the filters of "docker images --filter"
+// ref. https://docs.docker.com/reference/cli/docker/image/ls/#filter
+func filterImages(infos []imageInfo, imageFilters filters.Args) ([]imageInfo, error) {
+	danglingOnly, err := imageFilters.GetBoolOrDefault("dangling", false)
+	if err != nil {
+		return nil, err
+	}
+
+	var before, since *imageInfo
+	if imageFilters.Contains("before") {
+		if before, err = findImageInfo(infos, imageFilters.Get("before")); err != nil {
+			return nil, err
+		}
+	}
+	if imageFilters.Contains("since") {
+		if since, err = findImageInfo(infos, imageFilters.Get("since")); err != nil {
+			return nil, err
+		}
+	}
+
+	var filtered []imageInfo
+	for _, info := range infos {
+		if imageFilters.Contains("dangling") && danglingOnly != (len(info.repoTags) == 0) {
+			continue
+		}
+		if before != nil && !info.config.Created.Before(before.config.Created.Time) {
+			continue
+		}
+		if since != nil && !info.config.Created.After(since.config.Created.Time) {
+			continue
+		}
+		if !imageFilters.MatchKVList("label", info.config.Config.Labels) {
+			continue
+		}
+
+		if imageFilters.Contains("reference") {
+			// Only the matching tags are shown
+			var repoTags []string
+			for _, tag := range info.repoTags {
+				if matchReference(imageFilters.Get("reference"), tag) {
+					repoTags = append(repoTags, tag)
+				}
+			}
+			if len(repoTags) == 0 {
+				continue
+			}
+			info.repoTags = repoTags
+		}
+
+		filtered = append(filtered, info)
+	}
+	return filtered, nil
+}
+
+// findImageInfo looks up the image by tag or ID for the "before" and "since" filters
+func findImageInfo(infos []imageInfo, values []string) (*imageInfo, error) {
+	for _, value := range values {
+		for i, info := range infos {
+			if info.id == value || strings.TrimPrefix(info.id, "sha256:") == value {
+				return &infos[i], nil
+			}
+			for _, tag := range info.repoTags {
+				if familiarTag(tag) == familiarTag(value) {
+					return &infos[i], nil
+				}
+			}
+		}
+	}
+	return nil, errdefs.NotFound(xerrors.Errorf("No such image: %s", strings.Join(values, ", ")))
+}
+
+func matchReference(patterns []string, tag string) bool {
+	ref, err := reference.ParseNormalizedNamed(tag)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if matched, err := reference.FamiliarMatch(pattern, ref); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func manifestDescriptor(img v1.Image) (ocispec.Descriptor, error) {
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	d, err := img.Digest()
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	size, err := img.Size()
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return ocispec.Descriptor{
+		MediaType: string(mediaType),
+		Digest:    digest.Digest(d.String()),
+		Size:      size,
+	}, nil
+}
+
+func manifestSummary(info imageInfo, desc ocispec.Descriptor) image.ManifestSummary {
+	m := image.ManifestSummary{
+		ID:         desc.Digest.String(),
+		Descriptor: desc,
+		Available:  true,
+		Kind:       image.ManifestKindImage,
+		ImageData: &image.ImageProperties{
+			Platform: ocispec.Platform{
+				Architecture: info.config.Architecture,
+				OS:           info.config.OS,
+				OSVersion:    info.config.OSVersion,
+				Variant:      info.config.Variant,
+			},
+			Containers: []string{},
+		},
+	}
+	m.ImageData.Size.Unpacked = info.size()
+
+	// The content is the manifest, the config and the layers
+	m.Size.Content = desc.Size
+	if b, err := info.img.RawConfigFile(); err == nil {
+		m.Size.Content += int64(len(b))
+	}
+	if layers, err := info.img.Layers(); err == nil {
+		for _, layer := range layers {
+			if size, err := layer.Size(); err == nil {
+				m.Size.Content += size
+			}
+		}
+	}
+	m.Size.Total = m.Size.Content + m.ImageData.Size.Unpacked
+	return m
+}
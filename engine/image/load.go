@@ -0,0 +1,160 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/docker/docker/api/server/httputils"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/ioutils"
+	"github.com/docker/docker/pkg/progress"
+	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/docker/docker/pkg/stringid"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"golang.org/x/xerrors"
+
+	"github.com/aquasecurity/testdocker/tarfile"
+)
+
+// ref. https://github.com/moby/moby/blob/4f0d95fa6ee7f865597c03b9e63702cdcb0f7067/api/server/router/image/image_routes.go#L240
+func (s *imageRouter) postImagesLoad(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := httputils.ParseForm(r); err != nil {
+		return errdefs.InvalidParameter(err)
+	}
+	quiet := httputils.BoolValueOrDefault(r, "quiet", true)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	output := ioutils.NewWriteFlusher(w)
+	defer output.Close()
+
+	// The daemon reports errors in the stream only once it has started
+	if err := s.loadImage(r.Body, output, quiet); err != nil {
+		if !output.Flushed() {
+			return err
+		}
+		_, _ = output.Write(streamformatter.FormatError(err))
+	}
+	return nil
+}
+
+// loadImage registers the images in the docker-archive and writes the progress as the daemon does
+// ref. https://github.com/moby/moby/blob/4f0d95fa6ee7f865597c03b9e63702cdcb0f7067/image/tarexport/load.go#L32
+func (s *imageRouter) loadImage(in io.Reader, out io.Writer, quiet bool) error {
+	content, err := decompress(in)
+	if err != nil {
+		return xerrors.Errorf("unable to read the archive: %w", err)
+	}
+
+	sizes, err := entrySizes(content)
+	if err != nil {
+		return xerrors.Errorf("invalid tar archive: %w", err)
+	}
+
+	b, err := tarfile.ExtractFileFromTar(bytes.NewReader(content), "manifest.json")
+	if err != nil {
+		return err
+	}
+
+	var manifests tarball.Manifest
+	if err = json.Unmarshal(b, &manifests); err != nil {
+		return xerrors.Errorf("invalid manifest.json: %w", err)
+	}
+
+	var progressOutput progress.Output
+	if !quiet {
+		progressOutput = streamformatter.NewJSONProgressOutput(out, false)
+	}
+	stdout := streamformatter.NewStdoutWriter(out)
+
+	src := &imageSource{content: content}
+	for _, m := range manifests {
+		rawConfig, err := tarfile.ExtractFileFromTar(bytes.NewReader(content), m.Config)
+		if err != nil {
+			return err
+		}
+		config, err := v1.ParseConfigFile(bytes.NewReader(rawConfig))
+		if err != nil {
+			return xerrors.Errorf("invalid image config (%s): %w", m.Config, err)
+		}
+		if len(config.RootFS.DiffIDs) != len(m.Layers) {
+			return xerrors.Errorf("invalid manifest, layers length mismatch: expected %d, got %d",
+				len(config.RootFS.DiffIDs), len(m.Layers))
+		}
+
+		id, _, err := v1.SHA256(bytes.NewReader(rawConfig))
+		if err != nil {
+			return err
+		}
+
+		for i, layer := range m.Layers {
+			size, ok := sizes[layer]
+			if !ok {
+				return xerrors.Errorf("layer not found in the archive: %s", layer)
+			}
+			if progressOutput != nil {
+				progressOutput.WriteProgress(progress.Progress{
+					ID:      stringid.TruncateID(config.RootFS.DiffIDs[i].String()),
+					Action:  "Loading layer",
+					Current: size,
+					Total:   size,
+				})
+			}
+		}
+
+		s.store.add(src, id.String(), m.RepoTags)
+
+		if len(m.RepoTags) == 0 {
+			fmt.Fprintf(stdout, "Loaded image ID: %s\n", id)
+			continue
+		}
+		for _, tag := range m.RepoTags {
+			fmt.Fprintf(stdout, "Loaded image: %s\n", tag)
+		}
+	}
+	return nil
+}
+
+// decompress reads the plain or gzipped archive
+func decompress(in io.Reader) ([]byte, error) {
+	br := bufio.NewReader(in)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	r := io.Reader(br)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	}
+	return io.ReadAll(r)
+}
+
+// entrySizes returns the sizes of the files in the archive
+func entrySizes(content []byte) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	tr := tar.NewReader(bytes.NewReader(content))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		sizes[hdr.Name] = hdr.Size
+	}
+	return sizes, nil
+}
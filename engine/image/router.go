@@ -16,7 +16,6 @@ import (
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/storage"
 	"github.com/docker/docker/pkg/ioutils"
-	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"golang.org/x/xerrors"
@@ -25,13 +24,27 @@ import (
 // imageRouter is a router to talk with the image controller
 type imageRouter struct {
 	routes []router.Route
-	images map[string]string
+	store  *store
+}
+
+// Option is the option of the image router
+type Option struct {
+	// ImagePaths maps the image names to docker-archive tarballs
+	ImagePaths map[string]string
+
+	// ImagesInUse are the names or IDs of the images used by containers. They can't be removed without force.
+	ImagesInUse []string
 }
 
 // NewRouter initializes a new image router
 func NewRouter(images map[string]string) router.Router {
+	return NewRouterWithOption(Option{ImagePaths: images})
+}
+
+// NewRouterWithOption initializes a new image router with the option
+func NewRouterWithOption(option Option) router.Router {
 	r := &imageRouter{
-		images: images,
+		store: newStore(option.ImagePaths, option.ImagesInUse),
 	}
 	r.initRoutes()
 	return r
@@ -46,48 +59,28 @@ func (s *imageRouter) Routes() []router.Route {
 func (s *imageRouter) initRoutes() {
 	s.routes = []router.Route{
 		// GET
+		router.NewGetRoute("/images/json", s.getImagesJSON),
 		router.NewGetRoute("/images/{name:.*}/json", s.getImagesByName),
 		router.NewGetRoute("/images/{name:.*}/get", s.getImagesGet),
 		router.NewGetRoute("/images/get", s.getImagesGet),
 		router.NewGetRoute("/images/{name:.*}/history", s.getImageHistory),
+
+		// POST
+		router.NewPostRoute("/images/load", s.postImagesLoad),
+		router.NewPostRoute("/images/{name:.*}/tag", s.postImagesTag),
+
+		// DELETE
+		router.NewDeleteRoute("/images/{name:.*}", s.deleteImages),
 	}
 }
 
 // ref. https://github.com/moby/moby/blob/852542b3976754f62232f1fafca7fd35deeb1da3/api/server/router/image/image.go#L34
 func (s *imageRouter) getImagesByName(_ context.Context, w http.ResponseWriter, _ *http.Request, vars map[string]string) error {
-	imageName := vars["name"]
-	filePath, ok := s.images[imageName]
-	if !ok {
-		return errdefs.NotFound(xerrors.Errorf("unknown image: %s", imageName))
-	}
-
-	opener := func() (io.ReadCloser, error) {
-		return tarfile.Open(filePath)
-	}
-
-	img, err := tarball.Image(opener, nil)
-	if err != nil {
-		return errdefs.NotFound(xerrors.Errorf("unable to open the file path (%s): %w", filePath, err))
-	}
-
-	rc, err := tarfile.Open(filePath)
-	if err != nil {
-		return errdefs.NotFound(xerrors.Errorf("unable to open the file path (%s): %w", filePath, err))
-	}
-
-	b, err := tarfile.ExtractFileFromTar(rc, "manifest.json")
+	entry, err := s.store.lookup(vars["name"])
 	if err != nil {
-		return errdefs.Unavailable(err)
-	}
-
-	var manifests tarball.Manifest
-	if err := json.Unmarshal(b, &manifests); err != nil {
-		return errdefs.Unavailable(err)
-	}
-
-	if len(manifests) != 1 {
-		return errdefs.Unavailable(xerrors.New("tarball must contain only a single image to be used with testdocker"))
+		return err
 	}
+	img := entry.img
 
 	config, err := img.ConfigFile()
 	if err != nil {
@@ -156,7 +149,7 @@ func (s *imageRouter) getImagesByName(_ context.Context, w http.ResponseWriter,
 	}
 	inspect := image.InspectResponse{
 		ID:              manifest.Config.Digest.String(),
-		RepoTags:        manifests[0].RepoTags,
+		RepoTags:        s.store.repoTags(entry),
 		RepoDigests:     nil, // not supported
 		Parent:          "",  // not supported
 		Comment:         "",  // not supported
@@ -168,8 +161,8 @@ func (s *imageRouter) getImagesByName(_ context.Context, w http.ResponseWriter,
 		Architecture:    config.Architecture,
 		Os:              config.OS,
 		OsVersion:       config.OSVersion,
-		Size:            0,                       // not supported
-		VirtualSize:     0,                       // not supported
+		Size:            0,                    // not supported
+		VirtualSize:     0,                    // not supported
 		GraphDriver:     storage.DriverData{}, // not supported
 		RootFS: image.RootFS{
 			Type:   config.RootFS.Type,
@@ -208,21 +201,24 @@ func (s *imageRouter) getImagesGet(ctx context.Context, w http.ResponseWriter, r
 		return errdefs.InvalidParameter(err)
 	}
 
+	// Several images, or an image whose tarball doesn't hold exactly the requested tag, are written into a new archive
 	if len(names) > 1 {
-		err := xerrors.New("testdocker doesn't support multiple images")
-		return errdefs.InvalidParameter(err)
+		return s.writeArchive(output, names)
 	}
 
-	name := names[0]
-	filePath, ok := s.images[name]
-	if !ok {
-		return errdefs.NotFound(xerrors.Errorf("unknown image: %s", name))
+	entry, err := s.store.lookup(names[0])
+	if err != nil {
+		return err
+	}
+	if !s.exportable(entry, names[0]) {
+		return s.writeArchive(output, names)
 	}
 
-	f, err := tarfile.Open(filePath)
+	f, err := entry.src.open()
 	if err != nil {
-		return errdefs.NotFound(xerrors.Errorf("unknown image (%s): %w", filePath, err))
+		return errdefs.NotFound(xerrors.Errorf("unknown image (%s): %w", entry.src, err))
 	}
+	defer f.Close()
 
 	if _, err = io.Copy(w, f); err != nil {
 		return errdefs.Unavailable(err)
@@ -232,20 +228,11 @@ func (s *imageRouter) getImagesGet(ctx context.Context, w http.ResponseWriter, r
 }
 
 func (s *imageRouter) getImageHistory(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
-	imageName := vars["name"]
-	filePath, ok := s.images[imageName]
-	if !ok {
-		return errdefs.NotFound(xerrors.Errorf("unknown image: %s", imageName))
-	}
-
-	opener := func() (io.ReadCloser, error) {
-		return tarfile.Open(filePath)
-	}
-
-	img, err := tarball.Image(opener, nil)
+	entry, err := s.store.lookup(vars["name"])
 	if err != nil {
-		return errdefs.NotFound(xerrors.Errorf("unable to open the file path (%s): %w", filePath, err))
+		return err
 	}
+	img := entry.img
 	layers, err := img.Layers()
 	if err != nil {
 		return errdefs.Unavailable(err)
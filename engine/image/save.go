@@ -0,0 +1,209 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path"
+	"slices"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/errdefs"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// savedImage is an image to be written into the combined archive
+type savedImage struct {
+	img      v1.Image
+	repoTags []string
+}
+
+// writeArchive writes the images in one docker-archive as "docker save" does for several images.
+// The layers shared by the images are stored once.
+// ref. https://github.com/moby/moby/blob/4f0d95fa6ee7f865597c03b9e63702cdcb0f7067/image/tarexport/save.go
+func (s *imageRouter) writeArchive(w io.Writer, names []string) error {
+	images, err := s.savedImages(names)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	var manifests tarball.Manifest
+	repositories := map[string]map[string]string{}
+	seenLayers := map[v1.Hash]struct{}{}
+	for _, si := range images {
+		configName, err := si.img.ConfigName()
+		if err != nil {
+			return errdefs.Unavailable(err)
+		}
+		rawConfig, err := si.img.RawConfigFile()
+		if err != nil {
+			return errdefs.Unavailable(err)
+		}
+		configFile := configName.Hex + ".json"
+		if err = writeTarEntry(tw, configFile, rawConfig); err != nil {
+			return err
+		}
+
+		layers, err := si.img.Layers()
+		if err != nil {
+			return errdefs.Unavailable(err)
+		}
+
+		var layerFiles []string
+		for _, layer := range layers {
+			diffID, err := layer.DiffID()
+			if err != nil {
+				return errdefs.Unavailable(err)
+			}
+			layerFile := path.Join(diffID.Hex, "layer.tar")
+			layerFiles = append(layerFiles, layerFile)
+
+			if _, ok := seenLayers[diffID]; ok {
+				continue
+			}
+			seenLayers[diffID] = struct{}{}
+
+			if err = writeLayer(tw, layerFile, layer); err != nil {
+				return err
+			}
+		}
+
+		// The legacy "repositories" file points to the top layer of each tag
+		for _, repoTag := range si.repoTags {
+			repo, tag, ok := splitRepoTag(repoTag)
+			if !ok || len(layerFiles) == 0 {
+				continue
+			}
+			if repositories[repo] == nil {
+				repositories[repo] = map[string]string{}
+			}
+			repositories[repo][tag] = path.Dir(layerFiles[len(layerFiles)-1])
+		}
+
+		manifests = append(manifests, tarball.Descriptor{
+			Config:   configFile,
+			RepoTags: si.repoTags,
+			Layers:   layerFiles,
+		})
+	}
+
+	b, err := json.Marshal(manifests)
+	if err != nil {
+		return errdefs.Unavailable(err)
+	}
+	if err = writeTarEntry(tw, "manifest.json", b); err != nil {
+		return err
+	}
+
+	if len(repositories) > 0 {
+		if b, err = json.Marshal(repositories); err != nil {
+			return errdefs.Unavailable(err)
+		}
+		if err = writeTarEntry(tw, "repositories", b); err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return errdefs.Unavailable(err)
+	}
+	return nil
+}
+
+// savedImages resolves the names. The names of the same image are merged into one entry.
+func (s *imageRouter) savedImages(names []string) ([]*savedImage, error) {
+	var images []*savedImage
+	byID := map[v1.Hash]*savedImage{}
+	for _, name := range names {
+		entry, err := s.store.lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		id, err := entry.img.ConfigName()
+		if err != nil {
+			return nil, errdefs.Unavailable(err)
+		}
+
+		si, ok := byID[id]
+		if !ok {
+			si = &savedImage{img: entry.img, repoTags: []string{}}
+			byID[id] = si
+			images = append(images, si)
+		}
+
+		// The image saved by ID has no tags
+		if isImageID(name) {
+			continue
+		}
+		// Tags are written in the familiar form as "docker save" does, e.g. "alpine" as "alpine:latest"
+		if tag := familiarTag(name); !slices.Contains(si.repoTags, tag) {
+			si.repoTags = append(si.repoTags, tag)
+		}
+	}
+	return images, nil
+}
+
+// exportable reports whether the tarball of the image can be streamed as is for the name.
+// It must hold the image alone, tagged only with the name, and the tags must not have been changed at runtime.
+func (s *imageRouter) exportable(entry imageEntry, name string) bool {
+	if entry.shared || isImageID(name) {
+		return false
+	}
+	tags := familiarTags(entry.descriptor.RepoTags)
+	return slices.Equal(tags, []string{familiarTag(name)}) && slices.Equal(tags, familiarTags(s.store.repoTags(entry)))
+}
+
+func familiarTags(repoTags []string) []string {
+	var tags []string
+	for _, tag := range repoTags {
+		tags = append(tags, familiarTag(tag))
+	}
+	return tags
+}
+
+func writeLayer(tw *tar.Writer, name string, layer v1.Layer) error {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return errdefs.Unavailable(err)
+	}
+	defer rc.Close()
+
+	// The size must be known before writing the header
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return errdefs.Unavailable(err)
+	}
+	return writeTarEntry(tw, name, b)
+}
+
+func writeTarEntry(tw *tar.Writer, name string, b []byte) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(b)),
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return errdefs.Unavailable(err)
+	}
+	if _, err := io.Copy(tw, bytes.NewReader(b)); err != nil {
+		return errdefs.Unavailable(err)
+	}
+	return nil
+}
+
+// splitRepoTag splits a tag such as "alpine:3.11" into the familiar repository name and the tag
+func splitRepoTag(repoTag string) (string, string, bool) {
+	ref, err := reference.ParseNormalizedNamed(repoTag)
+	if err != nil {
+		return "", "", false
+	}
+	tagged, ok := reference.TagNameOnly(ref).(reference.NamedTagged)
+	if !ok {
+		return "", "", false
+	}
+	return reference.FamiliarName(tagged), tagged.Tag(), true
+}
@@ -0,0 +1,225 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"slices"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"golang.org/x/xerrors"
+
+	"github.com/aquasecurity/testdocker/tarfile"
+)
+
+// imageSource is a docker-archive tarball, "docker save" output, holding one or more images
+type imageSource struct {
+	filePath string // given in Option.ImagePaths
+	content  []byte // loaded with POST /images/load
+}
+
+// imageEntry is an image in the tarball
+type imageEntry struct {
+	src        *imageSource
+	id         string
+	img        v1.Image
+	descriptor tarball.Descriptor
+	shared     bool // the tarball holds other images as well
+}
+
+func (src *imageSource) open() (io.ReadCloser, error) {
+	if src.content != nil {
+		return io.NopCloser(bytes.NewReader(src.content)), nil
+	}
+	return tarfile.Open(src.filePath)
+}
+
+func (src *imageSource) String() string {
+	if src.content != nil {
+		return "loaded image"
+	}
+	return src.filePath
+}
+
+// manifest returns manifest.json of the tarball
+func (src *imageSource) manifest() (tarball.Manifest, error) {
+	rc, err := src.open()
+	if err != nil {
+		return nil, errdefs.NotFound(xerrors.Errorf("unable to open the file path (%s): %w", src, err))
+	}
+	defer rc.Close()
+
+	b, err := tarfile.ExtractFileFromTar(rc, "manifest.json")
+	if err != nil {
+		return nil, errdefs.Unavailable(err)
+	}
+
+	var manifests tarball.Manifest
+	if err = json.Unmarshal(b, &manifests); err != nil {
+		return nil, errdefs.Unavailable(err)
+	}
+	return manifests, nil
+}
+
+// entries returns all the images in the tarball
+func (src *imageSource) entries() ([]imageEntry, error) {
+	manifests, err := src.manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []imageEntry
+	for i := range manifests {
+		entry, err := src.entry(manifests, i)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// find returns the image in the tarball referred to by the tag or the config digest.
+// The only image of a single-image tarball is returned regardless of the name.
+func (src *imageSource) find(name string) (imageEntry, error) {
+	manifests, err := src.manifest()
+	if err != nil {
+		return imageEntry{}, err
+	}
+
+	if len(manifests) == 1 {
+		return src.entry(manifests, 0)
+	}
+
+	if i := findRepoTag(manifests, name); i >= 0 {
+		return src.entry(manifests, i)
+	}
+
+	for i, m := range manifests {
+		id, err := src.configDigest(m.Config)
+		if err != nil {
+			return imageEntry{}, err
+		}
+		if name == id.String() || name == id.Hex {
+			return src.entry(manifests, i)
+		}
+	}
+	return imageEntry{}, errdefs.NotFound(xerrors.Errorf("image not found in the tarball (%s): %s", src, name))
+}
+
+// findRepoTag returns the index of the image having the tag. Tags are compared in the familiar form,
+// so that "index.docker.io/library/alpine:3.11" matches "alpine:3.11".
+func findRepoTag(manifests tarball.Manifest, name string) int {
+	tag := familiarTag(name)
+	return slices.IndexFunc(manifests, func(m tarball.Descriptor) bool {
+		return slices.ContainsFunc(m.RepoTags, func(repoTag string) bool {
+			return familiarTag(repoTag) == tag
+		})
+	})
+}
+
+func (src *imageSource) entry(manifests tarball.Manifest, i int) (imageEntry, error) {
+	// tarball.Image selects the image in a multi-image tarball by tag.
+	// An untagged image can't be selected, so the other images are hidden from manifest.json.
+	opener := src.open
+	var tag *name.Tag
+	if len(manifests) > 1 {
+		if len(manifests[i].RepoTags) > 0 {
+			t, err := name.NewTag(manifests[i].RepoTags[0])
+			if err != nil {
+				return imageEntry{}, errdefs.Unavailable(err)
+			}
+			tag = &t
+		} else {
+			b, err := json.Marshal(tarball.Manifest{manifests[i]})
+			if err != nil {
+				return imageEntry{}, errdefs.Unavailable(err)
+			}
+			opener = func() (io.ReadCloser, error) {
+				return src.openWithManifest(b)
+			}
+		}
+	}
+
+	img, err := tarball.Image(opener, tag)
+	if err != nil {
+		return imageEntry{}, errdefs.NotFound(xerrors.Errorf("unable to open the file path (%s): %w", src, err))
+	}
+
+	configName, err := img.ConfigName()
+	if err != nil {
+		return imageEntry{}, errdefs.Unavailable(err)
+	}
+
+	return imageEntry{
+		src:        src,
+		id:         configName.String(),
+		img:        img,
+		descriptor: manifests[i],
+		shared:     len(manifests) > 1,
+	}, nil
+}
+
+// openWithManifest streams the tarball with manifest.json replaced
+func (src *imageSource) openWithManifest(manifest []byte) (io.ReadCloser, error) {
+	rc, err := src.open()
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer rc.Close()
+		pw.CloseWithError(replaceManifest(rc, pw, manifest))
+	}()
+	return pr, nil
+}
+
+func replaceManifest(r io.Reader, w io.Writer, manifest []byte) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		var body io.Reader = tr
+		if hdr.Name == "manifest.json" {
+			hdr.Size = int64(len(manifest))
+			body = bytes.NewReader(manifest)
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err = io.Copy(tw, body); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (src *imageSource) configDigest(configPath string) (v1.Hash, error) {
+	rc, err := src.open()
+	if err != nil {
+		return v1.Hash{}, errdefs.NotFound(xerrors.Errorf("unable to open the file path (%s): %w", src, err))
+	}
+	defer rc.Close()
+
+	b, err := tarfile.ExtractFileFromTar(rc, configPath)
+	if err != nil {
+		return v1.Hash{}, errdefs.Unavailable(err)
+	}
+
+	h, _, err := v1.SHA256(bytes.NewReader(b))
+	if err != nil {
+		return v1.Hash{}, errdefs.Unavailable(err)
+	}
+	return h, nil
+}
@@ -0,0 +1,302 @@
+package image
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+	"golang.org/x/xerrors"
+)
+
+// store is the mutable set of images in the engine.
+// The tarballs are never modified. Tags added or removed at runtime are kept in the store instead.
+type store struct {
+	mu      sync.RWMutex
+	sources map[string]*imageSource // tarballs keyed by the names given in Option.ImagePaths or loaded
+	tags    map[string]string       // tags changed at runtime, mapped to the image ID or "" if removed
+	deleted map[string]struct{}     // IDs of the removed images
+	inUse   []string                // names or IDs of the images used by containers
+}
+
+func newStore(images map[string]string, inUse []string) *store {
+	// Names of the same file share the source
+	byPath := map[string]*imageSource{}
+	sources := map[string]*imageSource{}
+	for name, filePath := range images {
+		if byPath[filePath] == nil {
+			byPath[filePath] = &imageSource{filePath: filePath}
+		}
+		sources[name] = byPath[filePath]
+	}
+
+	return &store{
+		sources: sources,
+		tags:    map[string]string{},
+		deleted: map[string]struct{}{},
+		inUse:   inUse,
+	}
+}
+
+// add registers the loaded image. The tags are moved from other images.
+func (s *store) add(src *imageSource, id string, repoTags []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.deleted, id)
+
+	// An untagged image can be referred to only by ID
+	if len(repoTags) == 0 {
+		s.sources[id] = src
+		return
+	}
+	for _, tag := range repoTags {
+		s.sources[tag] = src
+		s.tags[familiarTag(tag)] = id
+	}
+}
+
+// lookup returns the image referred to by the name or the ID
+func (s *store) lookup(name string) (imageEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lookupLocked(name)
+}
+
+// lookupLocked is lookup for the caller holding s.mu
+func (s *store) lookupLocked(name string) (imageEntry, error) {
+	if id, ok := s.tags[familiarTag(name)]; ok {
+		if id == "" {
+			return imageEntry{}, errdefs.NotFound(xerrors.Errorf("unknown image: %s", name))
+		}
+		return s.findByID(id)
+	}
+
+	if src, ok := s.sources[name]; ok {
+		entry, err := src.find(name)
+		if err != nil {
+			return imageEntry{}, err
+		}
+		if _, ok = s.deleted[entry.id]; ok {
+			return imageEntry{}, errdefs.NotFound(xerrors.Errorf("unknown image: %s", name))
+		}
+		return entry, nil
+	}
+
+	if isImageID(name) {
+		return s.findByID(name)
+	}
+	return s.findByTag(name)
+}
+
+// findByTag looks up the image by the tags in manifest.json of all the tarballs,
+// e.g. a tarball given with a name other than its tags in Option.ImagePaths
+func (s *store) findByTag(name string) (imageEntry, error) {
+	for _, src := range s.uniqueSources() {
+		manifests, err := src.manifest()
+		if err != nil {
+			return imageEntry{}, err
+		}
+		i := findRepoTag(manifests, name)
+		if i < 0 {
+			continue
+		}
+
+		entry, err := src.entry(manifests, i)
+		if err != nil {
+			return imageEntry{}, err
+		}
+		if _, ok := s.deleted[entry.id]; !ok {
+			return entry, nil
+		}
+	}
+	return imageEntry{}, errdefs.NotFound(xerrors.Errorf("unknown image: %s", name))
+}
+
+// findByID looks up the image by ID in all the tarballs
+func (s *store) findByID(id string) (imageEntry, error) {
+	if !strings.HasPrefix(id, "sha256:") {
+		id = "sha256:" + id
+	}
+	if _, ok := s.deleted[id]; !ok {
+		for _, src := range s.uniqueSources() {
+			entries, err := src.entries()
+			if err != nil {
+				return imageEntry{}, err
+			}
+			for _, entry := range entries {
+				if entry.id == id {
+					return entry, nil
+				}
+			}
+		}
+	}
+	return imageEntry{}, errdefs.NotFound(xerrors.Errorf("unknown image: %s", id))
+}
+
+// entries returns all the images in the engine
+func (s *store) entries() ([]imageEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []imageEntry
+	for _, src := range s.uniqueSources() {
+		srcEntries, err := src.entries()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range srcEntries {
+			if _, ok := s.deleted[entry.id]; !ok {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// uniqueSources returns the tarballs of all the images. A tarball with several names is returned once.
+func (s *store) uniqueSources() []*imageSource {
+	seen := map[*imageSource]struct{}{}
+	var sources []*imageSource
+	for _, src := range s.sources {
+		if _, ok := seen[src]; ok {
+			continue
+		}
+		seen[src] = struct{}{}
+		sources = append(sources, src)
+	}
+	return sources
+}
+
+// repoTags returns the current tags of the image
+func (s *store) repoTags(entry imageEntry) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.repoTagsLocked(entry)
+}
+
+// repoTagsLocked is repoTags for the caller holding s.mu
+func (s *store) repoTagsLocked(entry imageEntry) []string {
+	repoTags := []string{}
+	seen := map[string]struct{}{}
+	for _, tag := range entry.descriptor.RepoTags {
+		if id, ok := s.tags[familiarTag(tag)]; !ok || id == entry.id {
+			repoTags = append(repoTags, tag)
+			seen[familiarTag(tag)] = struct{}{}
+		}
+	}
+
+	var added []string
+	for tag, id := range s.tags {
+		if _, ok := seen[tag]; !ok && id == entry.id {
+			added = append(added, tag)
+		}
+	}
+	sort.Strings(added)
+	return append(repoTags, added...)
+}
+
+// tag adds the tag to the image. The tag is moved if another image has it.
+// ref. https://github.com/moby/moby/blob/4f0d95fa6ee7f865597c03b9e63702cdcb0f7067/daemon/images/image_tag.go
+func (s *store) tag(name, repoTag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.lookupLocked(name)
+	if err != nil {
+		return err
+	}
+	s.tags[repoTag] = entry.id
+	return nil
+}
+
+// remove untags the image and removes it once no tags remain, as the daemon does.
+// Parent images aren't tracked, so no images are pruned.
+// ref. https://github.com/moby/moby/blob/4f0d95fa6ee7f865597c03b9e63702cdcb0f7067/daemon/images/image_delete.go#L63
+func (s *store) remove(name string, force bool) ([]image.DeleteResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.lookupLocked(name)
+	if err != nil {
+		return nil, err
+	}
+
+	shortID := strings.TrimPrefix(entry.id, "sha256:")[:12]
+	repoTags := s.repoTagsLocked(entry)
+	inUse := s.inUseLocked(entry)
+
+	var records []image.DeleteResponse
+	if !isImageID(name) {
+		// Removing the last tag removes the image as well
+		if len(repoTags) <= 1 && inUse && !force {
+			return nil, errdefs.Conflict(xerrors.Errorf("conflict: unable to remove repository reference %q (must force) - container is using its referenced image %s", name, shortID))
+		}
+
+		tag := familiarTag(name)
+		s.tags[tag] = ""
+		records = append(records, image.DeleteResponse{Untagged: tag})
+
+		if len(s.repoTagsLocked(entry)) > 0 {
+			return records, nil
+		}
+	} else {
+		if len(repoTags) > 1 && !force {
+			return nil, errdefs.Conflict(xerrors.Errorf("conflict: unable to delete %s (must be forced) - image is referenced in multiple repositories", shortID))
+		}
+		if inUse && !force {
+			return nil, errdefs.Conflict(xerrors.Errorf("conflict: unable to delete %s (must be forced) - image is being used by stopped container", shortID))
+		}
+
+		for _, tag := range repoTags {
+			s.tags[familiarTag(tag)] = ""
+			records = append(records, image.DeleteResponse{Untagged: tag})
+		}
+	}
+
+	s.deleted[entry.id] = struct{}{}
+	records = append(records, image.DeleteResponse{Deleted: entry.id})
+	return records, nil
+}
+
+// inUseLocked reports whether the image is used by a container
+func (s *store) inUseLocked(entry imageEntry) bool {
+	for _, name := range s.inUse {
+		if used, err := s.lookupLocked(name); err == nil && used.id == entry.id {
+			return true
+		}
+	}
+	return false
+}
+
+// familiarTag normalizes the tag such as "docker.io/library/alpine" to "alpine:latest".
+// Names that aren't references are returned as is.
+func familiarTag(name string) string {
+	if isImageID(name) {
+		return name
+	}
+	ref, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return name
+	}
+	if _, ok := ref.(reference.Digested); ok {
+		return name
+	}
+	return reference.FamiliarString(reference.TagNameOnly(ref))
+}
+
+// isImageID reports whether the name is an image ID rather than a reference
+func isImageID(name string) bool {
+	hex := strings.TrimPrefix(name, "sha256:")
+	if len(hex) != 64 {
+		return false
+	}
+	for _, c := range hex {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
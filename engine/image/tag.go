@@ -0,0 +1,80 @@
+package image
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/server/httputils"
+	"github.com/docker/docker/errdefs"
+	"golang.org/x/xerrors"
+)
+
+// ref. https://github.com/moby/moby/blob/4f0d95fa6ee7f865597c03b9e63702cdcb0f7067/api/server/router/image/image_routes.go#L383
+func (s *imageRouter) postImagesTag(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := httputils.ParseForm(r); err != nil {
+		return errdefs.InvalidParameter(err)
+	}
+
+	repoTag, err := repoTagReference(r.Form.Get("repo"), r.Form.Get("tag"))
+	if err != nil {
+		return errdefs.InvalidParameter(err)
+	}
+
+	if err = s.store.tag(vars["name"], repoTag); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// ref. https://github.com/moby/moby/blob/4f0d95fa6ee7f865597c03b9e63702cdcb0f7067/api/server/router/image/image_routes.go#L303
+func (s *imageRouter) deleteImages(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := httputils.ParseForm(r); err != nil {
+		return errdefs.InvalidParameter(err)
+	}
+
+	name := vars["name"]
+	if strings.TrimSpace(name) == "" {
+		return errdefs.InvalidParameter(xerrors.New("image name cannot be blank"))
+	}
+
+	// "noprune" is accepted, but there are no parent images to prune
+	force := httputils.BoolValue(r, "force")
+
+	list, err := s.store.remove(name, force)
+	if err != nil {
+		return err
+	}
+
+	return httputils.WriteJSON(w, http.StatusOK, list)
+}
+
+// repoTagReference returns the tag such as "alpine:latest" from the "repo" and "tag" parameters
+func repoTagReference(repo, tag string) (string, error) {
+	if repo == "" {
+		return "", xerrors.New("repository name must be specified")
+	}
+
+	ref, err := reference.ParseNormalizedNamed(repo)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := ref.(reference.Digested); ok {
+		return "", xerrors.New("cannot import digest reference")
+	}
+	if reference.FamiliarName(ref) == "sha256" {
+		return "", xerrors.New("refusing to create an ambiguous tag using digest algorithm as name")
+	}
+
+	if tag != "" {
+		tagged, err := reference.WithTag(ref, tag)
+		if err != nil {
+			return "", err
+		}
+		return reference.FamiliarString(tagged), nil
+	}
+	return reference.FamiliarString(reference.TagNameOnly(ref)), nil
+}
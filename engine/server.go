@@ -8,7 +8,9 @@ import (
 
 	"github.com/docker/docker/api/server/router"
 
+	"github.com/aquasecurity/testdocker/auth"
 	"github.com/aquasecurity/testdocker/engine/image"
+	"github.com/aquasecurity/testdocker/engine/system"
 	"github.com/aquasecurity/testdocker/server"
 )
 
@@ -20,6 +22,16 @@ type Option struct {
 	APIVersion       string
 	ImagePaths       map[string]string
 	UnixDomainSocket string
+
+	// ImagesInUse are the names or IDs of the images used by containers. DELETE /images/{name} fails with a conflict without force.
+	ImagesInUse []string
+
+	// RegistryAuth is the auth of the registry to log in to with POST /auth. Give the same value as registry.Option.Auth.
+	// RS256 and ES256 need SigningKey so that the registry accepts the identity tokens. NewDockerEngine panics without it.
+	RegistryAuth auth.Auth
+
+	// RegistryBasicAuth must be true if the registry is started with registry.Option.BasicAuth
+	RegistryBasicAuth bool
 }
 
 func NewDockerEngine(opt Option) *httptest.Server {
@@ -27,8 +39,18 @@ func NewDockerEngine(opt Option) *httptest.Server {
 		opt.APIVersion = defaultAPIVersion
 	}
 
+	// A key generated by the engine differs from the registry's, so the registry would reject every identity token
+	a := opt.RegistryAuth
+	if a.SigningMethod != "" && a.SigningMethod != auth.SigningMethodHS256 && a.SigningKey == nil && !opt.RegistryBasicAuth {
+		panic(fmt.Sprintf("RegistryAuth with %s requires SigningKey shared with the registry", a.SigningMethod))
+	}
+
 	var routes []router.Router
-	routes = append(routes, image.NewRouter(opt.ImagePaths))
+	routes = append(routes, image.NewRouterWithOption(image.Option{
+		ImagePaths:  opt.ImagePaths,
+		ImagesInUse: opt.ImagesInUse,
+	}))
+	routes = append(routes, system.NewRouter(opt.RegistryAuth, opt.RegistryBasicAuth))
 
 	m := server.CreateMux(routes)
 	m.Path("/_ping").Methods("GET").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
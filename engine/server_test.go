@@ -1 +1,1074 @@
 package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aquasecurity/testdocker/auth"
+	testregistry "github.com/aquasecurity/testdocker/registry"
+	"github.com/aquasecurity/testdocker/tarfile"
+)
+
+func TestNewDockerEngine_postAuth(t *testing.T) {
+	a := auth.Auth{
+		User:     "test",
+		Password: "testpass",
+		Secret:   "foo-is-the-secret",
+	}
+
+	testCases := []struct {
+		name                  string
+		config                registry.AuthConfig
+		basicAuth             bool
+		expectedStatusCode    int
+		expectedIdentityToken bool
+	}{
+		{
+			name: "happy path, identity token is returned",
+			config: registry.AuthConfig{
+				Username: "test",
+				Password: "testpass",
+			},
+			expectedStatusCode:    http.StatusOK,
+			expectedIdentityToken: true,
+		},
+		{
+			name: "happy path, legacy auth",
+			config: registry.AuthConfig{
+				Auth: "dGVzdDp0ZXN0cGFzcw==",
+			},
+			expectedStatusCode:    http.StatusOK,
+			expectedIdentityToken: true,
+		},
+		{
+			name: "happy path, registry with Basic auth",
+			config: registry.AuthConfig{
+				Username: "test",
+				Password: "testpass",
+			},
+			basicAuth:          true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "sad path, incorrect password",
+			config: registry.AuthConfig{
+				Username: "test",
+				Password: "bogus",
+			},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name: "sad path, invalid identity token",
+			config: registry.AuthConfig{
+				IdentityToken: "bogus",
+			},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := testregistry.NewDockerRegistry(testregistry.Option{
+				Auth:      a,
+				BasicAuth: tc.basicAuth,
+			})
+			defer r.Close()
+
+			e := NewDockerEngine(Option{
+				RegistryAuth:      a,
+				RegistryBasicAuth: tc.basicAuth,
+			})
+			defer e.Close()
+
+			tc.config.ServerAddress = r.URL
+			code, got := postAuth(t, e.URL, tc.config)
+			assert.Equal(t, tc.expectedStatusCode, code, tc.name)
+			if code != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "Login Succeeded", got.Status, tc.name)
+			assert.Equal(t, tc.expectedIdentityToken, got.IdentityToken != "", tc.name)
+			if !tc.expectedIdentityToken {
+				return
+			}
+
+			// the registry accepts the identity token
+			resp, err := http.PostForm(r.URL+"/token", url.Values{
+				"grant_type":    {"refresh_token"},
+				"refresh_token": {got.IdentityToken},
+				"service":       {"testdocker"},
+			})
+			require.NoError(t, err, tc.name)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode, tc.name)
+
+			// and so does the engine
+			code, _ = postAuth(t, e.URL, registry.AuthConfig{
+				ServerAddress: r.URL,
+				IdentityToken: got.IdentityToken,
+			})
+			assert.Equal(t, http.StatusOK, code, tc.name)
+		})
+	}
+
+	t.Run("sad path, asymmetric signing without the shared key", func(t *testing.T) {
+		assert.Panics(t, func() {
+			NewDockerEngine(Option{
+				RegistryAuth: auth.Auth{
+					User:          "test",
+					Password:      "testpass",
+					SigningMethod: auth.SigningMethodRS256,
+				},
+			})
+		})
+	})
+}
+
+func postAuth(t *testing.T, engineURL string, config registry.AuthConfig) (int, registry.AuthenticateOKBody) {
+	b, err := json.Marshal(config)
+	require.NoError(t, err)
+
+	resp, err := http.Post(engineURL+"/v1.45/auth", "application/json", bytes.NewReader(b))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var got registry.AuthenticateOKBody
+	if resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	}
+	return resp.StatusCode, got
+}
+
+func TestNewDockerEngine_getImagesJSON(t *testing.T) {
+	base := mustImage(t, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	layer, err := random.Layer(100, types.DockerLayer)
+	require.NoError(t, err)
+	derived, err := mutate.AppendLayers(base, layer)
+	require.NoError(t, err)
+	derived = mustMutateConfig(t, derived, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), map[string]string{"env": "prod"})
+
+	images := map[string]v1.Image{
+		"foo/bar": base,
+		"prod":    derived,
+		"dev":     mustImage(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), map[string]string{"env": "dev"}),
+		"none":    mustImage(t, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), nil),
+	}
+	ids := map[string]string{}
+	for key, img := range images {
+		configName, err := img.ConfigName()
+		require.NoError(t, err)
+		ids[key] = configName.String()
+	}
+
+	dir := t.TempDir()
+	devPath := mustImageTarball(t, dir, images["dev"], "alpine:3.11", "alpine:latest")
+	imagePaths := map[string]string{
+		"foo/bar:1.0":   mustImageTarball(t, dir, base, "foo/bar:1.0"),
+		"alpine:3.10":   mustImageTarball(t, dir, derived, "alpine:3.10"),
+		"alpine:3.11":   devPath,
+		"alpine:latest": devPath,
+		ids["none"]:     mustImageTarball(t, dir, images["none"]),
+	}
+
+	testCases := []struct {
+		name               string
+		query              string
+		version            string
+		expectedStatusCode int
+		expectedIDs        []string
+		expectedRepoTags   [][]string
+	}{
+		{
+			name:               "happy path, all images",
+			expectedStatusCode: http.StatusOK,
+			expectedIDs:        []string{ids["none"], ids["dev"], ids["prod"], ids["foo/bar"]},
+			expectedRepoTags:   [][]string{{}, {"alpine:3.11", "alpine:latest"}, {"alpine:3.10"}, {"foo/bar:1.0"}},
+		},
+		{
+			name:               "happy path, reference",
+			query:              `filters={"reference":{"alpine":true}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedIDs:        []string{ids["dev"], ids["prod"]},
+			expectedRepoTags:   [][]string{{"alpine:3.11", "alpine:latest"}, {"alpine:3.10"}},
+		},
+		{
+			name:               "happy path, reference with a pattern",
+			query:              `filters={"reference":{"alpine:3.1*":true}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedIDs:        []string{ids["dev"], ids["prod"]},
+			expectedRepoTags:   [][]string{{"alpine:3.11"}, {"alpine:3.10"}},
+		},
+		{
+			name:               "happy path, label",
+			query:              `filters={"label":{"env=prod":true}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedIDs:        []string{ids["prod"]},
+		},
+		{
+			name:               "happy path, label key",
+			query:              `filters={"label":{"env":true}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedIDs:        []string{ids["dev"], ids["prod"]},
+		},
+		{
+			name:               "happy path, dangling",
+			query:              `filters={"dangling":{"true":true}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedIDs:        []string{ids["none"]},
+		},
+		{
+			name:               "happy path, not dangling",
+			query:              `filters={"dangling":{"false":true}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedIDs:        []string{ids["dev"], ids["prod"], ids["foo/bar"]},
+		},
+		{
+			name:               "happy path, before",
+			query:              `filters={"before":{"alpine":true}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedIDs:        []string{ids["prod"], ids["foo/bar"]},
+		},
+		{
+			name:               "happy path, before with a normalized name",
+			query:              `filters={"before":{"docker.io/library/alpine:3.11":true}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedIDs:        []string{ids["prod"], ids["foo/bar"]},
+		},
+		{
+			name:               "happy path, since",
+			query:              `filters={"since":{"alpine:3.10":true}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedIDs:        []string{ids["none"], ids["dev"]},
+		},
+		{
+			name:               "happy path, old API shows dangling images as <none>",
+			query:              `filters={"dangling":{"true":true}}`,
+			version:            "1.41",
+			expectedStatusCode: http.StatusOK,
+			expectedIDs:        []string{ids["none"]},
+			expectedRepoTags:   [][]string{{"<none>:<none>"}},
+		},
+		{
+			name:               "sad path, unknown image for before",
+			query:              `filters={"before":{"bogus":true}}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "sad path, invalid filter",
+			query:              `filters={"bogus":{"foo":true}}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	e := NewDockerEngine(Option{
+		ImagePaths: imagePaths,
+	})
+	defer e.Close()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			version := tc.version
+			if version == "" {
+				version = "1.45"
+			}
+			code, got := getImagesJSON(t, e.URL, version, tc.query)
+			assert.Equal(t, tc.expectedStatusCode, code, tc.name)
+			if code != http.StatusOK {
+				return
+			}
+
+			var gotIDs []string
+			var gotRepoTags [][]string
+			for _, summary := range got {
+				gotIDs = append(gotIDs, summary.ID)
+				gotRepoTags = append(gotRepoTags, summary.RepoTags)
+				assert.Equal(t, int64(-1), summary.SharedSize, tc.name)
+				assert.Empty(t, summary.Manifests, tc.name)
+			}
+			assert.Equal(t, tc.expectedIDs, gotIDs, tc.name)
+			if tc.expectedRepoTags != nil {
+				assert.Equal(t, tc.expectedRepoTags, gotRepoTags, tc.name)
+			}
+		})
+	}
+
+	t.Run("shared-size and manifests", func(t *testing.T) {
+		code, got := getImagesJSON(t, e.URL, "1.47", `shared-size=1&manifests=1&filters={"reference":{"foo/bar":true,"alpine:3.10":true}}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, got, 2)
+
+		prod, fooBar := got[0], got[1]
+		assert.Equal(t, ids["prod"], prod.ID)
+		assert.Greater(t, prod.Size, fooBar.Size)
+		// only the base layer is shared
+		assert.Equal(t, fooBar.Size, prod.SharedSize)
+		assert.Equal(t, fooBar.Size, fooBar.SharedSize)
+
+		digest, err := derived.Digest()
+		require.NoError(t, err)
+		require.Len(t, prod.Manifests, 1)
+		assert.Equal(t, digest.String(), prod.Manifests[0].ID)
+		assert.Equal(t, prod.Size, prod.Manifests[0].ImageData.Size.Unpacked)
+		assert.True(t, prod.Manifests[0].Available)
+	})
+}
+
+func mustImage(t *testing.T, created time.Time, labels map[string]string) v1.Image {
+	img, err := random.Image(100, 1)
+	require.NoError(t, err)
+	return mustMutateConfig(t, img, created, labels)
+}
+
+func mustMutateConfig(t *testing.T, img v1.Image, created time.Time, labels map[string]string) v1.Image {
+	config, err := img.ConfigFile()
+	require.NoError(t, err)
+	config = config.DeepCopy()
+	config.Created = v1.Time{Time: created}
+	config.Config.Labels = labels
+
+	img, err = mutate.ConfigFile(img, config)
+	require.NoError(t, err)
+	return img
+}
+
+// mustImageTarball writes the image in the "docker save" format. The image is dangling if no tags are given.
+func mustImageTarball(t *testing.T, dir string, img v1.Image, tags ...string) string {
+	d, err := img.Digest()
+	require.NoError(t, err)
+
+	refs := map[name.Reference]v1.Image{}
+	var repoTags []string
+	for _, tag := range tags {
+		ref, err := name.NewTag(tag)
+		require.NoError(t, err)
+		refs[ref] = img
+		repoTags = append(repoTags, ref.String())
+	}
+	if len(tags) == 0 {
+		ref, err := name.NewDigest("dangling@" + d.String())
+		require.NoError(t, err)
+		refs[ref] = img
+	}
+
+	filePath := filepath.Join(dir, d.Hex+".tar")
+	require.NoError(t, tarball.MultiRefWriteToFile(filePath, refs))
+
+	// The tags are written from the map in random order
+	if len(repoTags) > 1 {
+		mustRewriteRepoTags(t, filePath, repoTags)
+	}
+	return filePath
+}
+
+func mustRewriteRepoTags(t *testing.T, filePath string, repoTags []string) {
+	b, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(b))
+	tw := tar.NewWriter(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		if hdr.Name == "manifest.json" {
+			var manifest tarball.Manifest
+			require.NoError(t, json.Unmarshal(content, &manifest))
+			require.Len(t, manifest, 1)
+			manifest[0].RepoTags = repoTags
+			content, err = json.Marshal(manifest)
+			require.NoError(t, err)
+			hdr.Size = int64(len(content))
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, os.WriteFile(filePath, buf.Bytes(), 0644))
+}
+
+func getImagesJSON(t *testing.T, engineURL, version, query string) (int, []image.Summary) {
+	resp, err := http.Get(fmt.Sprintf("%s/v%s/images/json?%s", engineURL, version, query))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var got []image.Summary
+	if resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	}
+	return resp.StatusCode, got
+}
+
+func TestNewDockerEngine_postImagesLoad(t *testing.T) {
+	tagged := mustImage(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	untagged := mustImage(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	taggedID, err := tagged.ConfigName()
+	require.NoError(t, err)
+	untaggedID, err := untagged.ConfigName()
+	require.NoError(t, err)
+	layers, err := tagged.Layers()
+	require.NoError(t, err)
+	diffID, err := layers[0].DiffID()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	taggedPath := mustImageTarball(t, dir, tagged, "alpine:3.11", "alpine:latest")
+	untaggedPath := mustImageTarball(t, dir, untagged)
+
+	// The layers are removed from the archive
+	b, err := os.ReadFile(taggedPath)
+	require.NoError(t, err)
+	var noLayers bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(b))
+	tw := tar.NewWriter(&noLayers)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if strings.HasSuffix(hdr.Name, ".tar.gz") {
+			continue
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = io.Copy(tw, tr)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	noLayersPath := filepath.Join(dir, "no-layers.tar")
+	require.NoError(t, os.WriteFile(noLayersPath, noLayers.Bytes(), 0644))
+
+	testCases := []struct {
+		name               string
+		filePath           string
+		gzip               bool
+		query              string
+		expectedStatusCode int
+		expectedMessages   []jsonmessage.JSONMessage
+		expectedImages     map[string]string
+	}{
+		{
+			name:               "happy path",
+			filePath:           taggedPath,
+			expectedStatusCode: http.StatusOK,
+			expectedMessages: []jsonmessage.JSONMessage{
+				{Stream: "Loaded image: alpine:3.11\n"},
+				{Stream: "Loaded image: alpine:latest\n"},
+			},
+			expectedImages: map[string]string{
+				"alpine:3.11":   taggedID.String(),
+				"alpine:latest": taggedID.String(),
+			},
+		},
+		{
+			name:               "happy path, gzipped with progress",
+			filePath:           taggedPath,
+			gzip:               true,
+			query:              "quiet=0",
+			expectedStatusCode: http.StatusOK,
+			expectedMessages: []jsonmessage.JSONMessage{
+				{ID: diffID.Hex[:12], Status: "Loading layer"},
+				{Stream: "Loaded image: alpine:3.11\n"},
+				{Stream: "Loaded image: alpine:latest\n"},
+			},
+			expectedImages: map[string]string{
+				"alpine:3.11": taggedID.String(),
+			},
+		},
+		{
+			name:               "happy path, untagged image",
+			filePath:           untaggedPath,
+			expectedStatusCode: http.StatusOK,
+			expectedMessages: []jsonmessage.JSONMessage{
+				{Stream: fmt.Sprintf("Loaded image ID: %s\n", untaggedID)},
+			},
+			expectedImages: map[string]string{
+				untaggedID.String(): untaggedID.String(),
+			},
+		},
+		{
+			name:               "sad path, not a docker archive",
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:               "sad path, missing layers",
+			filePath:           noLayersPath,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:               "sad path, missing layers with progress",
+			filePath:           noLayersPath,
+			query:              "quiet=0",
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := NewDockerEngine(Option{})
+			defer ts.Close()
+
+			var body bytes.Buffer
+			if tc.filePath != "" {
+				b, err := os.ReadFile(tc.filePath)
+				require.NoError(t, err)
+				if tc.gzip {
+					gw := gzip.NewWriter(&body)
+					_, err = gw.Write(b)
+					require.NoError(t, err)
+					require.NoError(t, gw.Close())
+				} else {
+					body.Write(b)
+				}
+			}
+
+			resp, err := http.Post(fmt.Sprintf("%s/v1.45/images/load?%s", ts.URL, tc.query), "application/x-tar", &body)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tc.expectedStatusCode, resp.StatusCode)
+			if tc.expectedStatusCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+			var got []jsonmessage.JSONMessage
+			decoder := json.NewDecoder(resp.Body)
+			for decoder.More() {
+				var msg jsonmessage.JSONMessage
+				require.NoError(t, decoder.Decode(&msg))
+				// The progress bar depends on the terminal width
+				msg.Progress, msg.ProgressMessage, msg.Error = nil, "", nil
+				got = append(got, msg)
+			}
+			assert.Equal(t, tc.expectedMessages, got)
+
+			for name, expectedID := range tc.expectedImages {
+				resp, err := http.Get(fmt.Sprintf("%s/v1.45/images/%s/json", ts.URL, name))
+				require.NoError(t, err)
+				var inspect image.InspectResponse
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&inspect))
+				resp.Body.Close()
+				assert.Equal(t, expectedID, inspect.ID, name)
+			}
+		})
+	}
+}
+
+func TestNewDockerEngine_getImagesGet(t *testing.T) {
+	base := mustImage(t, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	layer, err := random.Layer(100, types.DockerLayer)
+	require.NoError(t, err)
+	derived, err := mutate.AppendLayers(base, layer)
+	require.NoError(t, err)
+
+	baseLayers, err := base.Layers()
+	require.NoError(t, err)
+	baseDiffID, err := baseLayers[0].DiffID()
+	require.NoError(t, err)
+	topDiffID, err := layer.DiffID()
+	require.NoError(t, err)
+	baseID, err := base.ConfigName()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	basePath := mustImageTarball(t, dir, base, "alpine:3.10", "alpine:latest")
+	imagePaths := map[string]string{
+		"alpine:3.10":   basePath,
+		"alpine:latest": basePath,
+		"foo/bar:1.0":   mustImageTarball(t, dir, derived, "foo/bar:1.0"),
+	}
+
+	testCases := []struct {
+		name                 string
+		query                string
+		expectedStatusCode   int
+		expectedRepoTags     [][]string
+		expectedRepositories map[string]map[string]string
+		expectedLayers       int
+	}{
+		{
+			name:               "happy path, several images",
+			query:              "names=alpine:3.10&names=foo/bar:1.0&names=alpine:latest",
+			expectedStatusCode: http.StatusOK,
+			expectedRepoTags:   [][]string{{"alpine:3.10", "alpine:latest"}, {"foo/bar:1.0"}},
+			expectedRepositories: map[string]map[string]string{
+				"alpine":  {"3.10": baseDiffID.Hex, "latest": baseDiffID.Hex},
+				"foo/bar": {"1.0": topDiffID.Hex},
+			},
+			expectedLayers: 2,
+		},
+		{
+			name:               "happy path, IDs and familiar tags",
+			query:              "names=" + baseID.Hex + "&names=alpine&names=docker.io/foo/bar:1.0",
+			expectedStatusCode: http.StatusOK,
+			expectedRepoTags:   [][]string{{"alpine:latest"}, {"foo/bar:1.0"}},
+			expectedRepositories: map[string]map[string]string{
+				"alpine":  {"latest": baseDiffID.Hex},
+				"foo/bar": {"1.0": topDiffID.Hex},
+			},
+			expectedLayers: 2,
+		},
+		{
+			name:               "sad path, unknown image",
+			query:              "names=alpine:3.10&names=unknown",
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := NewDockerEngine(Option{ImagePaths: imagePaths})
+			defer ts.Close()
+
+			resp, err := http.Get(fmt.Sprintf("%s/v1.45/images/get?%s", ts.URL, tc.query))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tc.expectedStatusCode, resp.StatusCode)
+			if tc.expectedStatusCode != http.StatusOK {
+				return
+			}
+
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			var layers int
+			tr := tar.NewReader(bytes.NewReader(b))
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				if path.Base(hdr.Name) == "layer.tar" {
+					layers++
+				}
+			}
+			assert.Equal(t, tc.expectedLayers, layers)
+
+			opener := func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(b)), nil
+			}
+
+			manifest, err := tarball.LoadManifest(opener)
+			require.NoError(t, err)
+			var gotRepoTags [][]string
+			for _, m := range manifest {
+				gotRepoTags = append(gotRepoTags, m.RepoTags)
+			}
+			assert.Equal(t, tc.expectedRepoTags, gotRepoTags)
+
+			rc, err := opener()
+			require.NoError(t, err)
+			raw, err := tarfile.ExtractFileFromTar(rc, "repositories")
+			require.NoError(t, err)
+			var gotRepositories map[string]map[string]string
+			require.NoError(t, json.Unmarshal(raw, &gotRepositories))
+			assert.Equal(t, tc.expectedRepositories, gotRepositories)
+
+			// Every image can be read back from the archive
+			for _, repoTags := range tc.expectedRepoTags {
+				tag, err := name.NewTag(repoTags[0])
+				require.NoError(t, err)
+				img, err := tarball.Image(opener, &tag)
+				require.NoError(t, err)
+				_, err = img.ConfigFile()
+				require.NoError(t, err)
+				imgLayers, err := img.Layers()
+				require.NoError(t, err)
+				for _, l := range imgLayers {
+					_, err = l.Digest()
+					require.NoError(t, err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewDockerEngine_multiImageTarball(t *testing.T) {
+	alpine := mustImage(t, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	debian := mustImage(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	dangling := mustImage(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+
+	ids := map[string]string{}
+	refs := map[name.Reference]v1.Image{}
+	for tag, img := range map[string]v1.Image{"alpine:3.11": alpine, "debian:buster": debian, "": dangling} {
+		configName, err := img.ConfigName()
+		require.NoError(t, err)
+		ids[tag] = configName.String()
+
+		var ref name.Reference
+		if tag == "" {
+			d, err := img.Digest()
+			require.NoError(t, err)
+			ref, err = name.NewDigest("dangling@" + d.String())
+			require.NoError(t, err)
+		} else {
+			ref, err = name.NewTag(tag)
+			require.NoError(t, err)
+		}
+		refs[ref] = img
+	}
+
+	filePath := filepath.Join(t.TempDir(), "suite.tar")
+	require.NoError(t, tarball.MultiRefWriteToFile(filePath, refs))
+
+	ts := NewDockerEngine(Option{
+		ImagePaths: map[string]string{
+			"alpine:3.11":   filePath,
+			"debian:buster": filePath,
+			ids[""]:         filePath,
+			"unknown:1.0":   filePath,
+		},
+	})
+	defer ts.Close()
+
+	testCases := []struct {
+		name               string
+		imageName          string
+		expectedStatusCode int
+		expectedID         string
+		expectedRepoTags   []string
+	}{
+		{
+			name:               "happy path, by tag",
+			imageName:          "alpine:3.11",
+			expectedStatusCode: http.StatusOK,
+			expectedID:         ids["alpine:3.11"],
+			expectedRepoTags:   []string{"alpine:3.11"},
+		},
+		{
+			name:               "happy path, another tag",
+			imageName:          "debian:buster",
+			expectedStatusCode: http.StatusOK,
+			expectedID:         ids["debian:buster"],
+			expectedRepoTags:   []string{"debian:buster"},
+		},
+		{
+			name:               "happy path, by config digest",
+			imageName:          ids[""],
+			expectedStatusCode: http.StatusOK,
+			expectedID:         ids[""],
+			expectedRepoTags:   []string{},
+		},
+		{
+			name:               "sad path, not in the tarball",
+			imageName:          "unknown:1.0",
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.Get(fmt.Sprintf("%s/v1.45/images/%s/json", ts.URL, tc.imageName))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, tc.expectedStatusCode, resp.StatusCode)
+			if tc.expectedStatusCode != http.StatusOK {
+				return
+			}
+
+			var inspect image.InspectResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&inspect))
+			assert.Equal(t, tc.expectedID, inspect.ID)
+			assert.Equal(t, tc.expectedRepoTags, inspect.RepoTags)
+
+			resp, err = http.Get(fmt.Sprintf("%s/v1.45/images/%s/history", ts.URL, tc.imageName))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			var history []image.HistoryResponseItem
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+			assert.NotEmpty(t, history)
+
+			// Only the requested image is exported
+			resp, err = http.Get(fmt.Sprintf("%s/v1.45/images/%s/get", ts.URL, tc.imageName))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			manifest, err := tarball.LoadManifest(func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(b)), nil
+			})
+			require.NoError(t, err)
+			require.Len(t, manifest, 1)
+			assert.Equal(t, tc.expectedID, "sha256:"+strings.TrimSuffix(manifest[0].Config, ".json"))
+		})
+	}
+
+	t.Run("happy path, resolved by the tags in the tarball", func(t *testing.T) {
+		ts := NewDockerEngine(Option{
+			ImagePaths: map[string]string{
+				"suite":                               filePath,
+				"index.docker.io/library/alpine:3.11": filePath,
+			},
+		})
+		defer ts.Close()
+
+		for imageName, expectedID := range map[string]string{
+			"index.docker.io/library/alpine:3.11": ids["alpine:3.11"],
+			"alpine:3.11":                         ids["alpine:3.11"],
+			"debian:buster":                       ids["debian:buster"],
+			"docker.io/library/debian:buster":     ids["debian:buster"],
+		} {
+			resp, err := http.Get(fmt.Sprintf("%s/v1.45/images/%s/json", ts.URL, imageName))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode, imageName)
+
+			var inspect image.InspectResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&inspect))
+			resp.Body.Close()
+			assert.Equal(t, expectedID, inspect.ID, imageName)
+		}
+	})
+
+	t.Run("happy path, gzipped tarball with a large config", func(t *testing.T) {
+		large := mustImage(t, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), map[string]string{
+			"large": strings.Repeat("x", 200*1024),
+		})
+		configName, err := large.ConfigName()
+		require.NoError(t, err)
+
+		largeTag, err := name.NewTag("large:1.0")
+		require.NoError(t, err)
+		alpineTag, err := name.NewTag("alpine:3.11")
+		require.NoError(t, err)
+
+		largePath := filepath.Join(t.TempDir(), "large.tar")
+		require.NoError(t, tarball.MultiRefWriteToFile(largePath, map[name.Reference]v1.Image{
+			largeTag:  large,
+			alpineTag: alpine,
+		}))
+
+		// The config is read from the gzip stream in several chunks
+		raw, err := os.ReadFile(largePath)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		_, err = gw.Write(raw)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		gzPath := largePath + ".gz"
+		require.NoError(t, os.WriteFile(gzPath, buf.Bytes(), 0644))
+
+		ts := NewDockerEngine(Option{
+			ImagePaths: map[string]string{
+				configName.String(): gzPath,
+			},
+		})
+		defer ts.Close()
+
+		resp, err := http.Get(fmt.Sprintf("%s/v1.45/images/%s/json", ts.URL, configName))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var inspect image.InspectResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&inspect))
+		assert.Equal(t, configName.String(), inspect.ID)
+	})
+
+	t.Run("happy path, list", func(t *testing.T) {
+		code, got := getImagesJSON(t, ts.URL, "1.45", "")
+		require.Equal(t, http.StatusOK, code)
+
+		var gotIDs []string
+		for _, summary := range got {
+			gotIDs = append(gotIDs, summary.ID)
+		}
+		assert.Equal(t, []string{ids[""], ids["debian:buster"], ids["alpine:3.11"]}, gotIDs)
+	})
+}
+
+func TestNewDockerEngine_tagAndDeleteImages(t *testing.T) {
+	alpine := mustImage(t, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	debian := mustImage(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	alpineID, err := alpine.ConfigName()
+	require.NoError(t, err)
+	debianID, err := debian.ConfigName()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	alpinePath := mustImageTarball(t, dir, alpine, "alpine:3.11", "alpine:latest")
+	ts := NewDockerEngine(Option{
+		ImagePaths: map[string]string{
+			"alpine:3.11":   alpinePath,
+			"alpine:latest": alpinePath,
+			"debian:buster": mustImageTarball(t, dir, debian, "debian:buster"),
+		},
+		ImagesInUse: []string{"debian:buster"},
+	})
+	defer ts.Close()
+
+	// The steps share the engine
+	steps := []struct {
+		name               string
+		method             string
+		path               string
+		expectedStatusCode int
+		expectedDeleted    []image.DeleteResponse
+		expectedRepoTags   map[string][]string // inspected after the step
+		expectedSavedTags  map[string][]string // exported after the step
+	}{
+		{
+			name:               "happy path, tag",
+			method:             http.MethodPost,
+			path:               "/images/alpine:3.11/tag?repo=myalpine&tag=v1",
+			expectedStatusCode: http.StatusCreated,
+			expectedRepoTags: map[string][]string{
+				"myalpine:v1": {"alpine:3.11", "alpine:latest", "myalpine:v1"},
+			},
+			expectedSavedTags: map[string][]string{
+				"myalpine:v1":   {"myalpine:v1"},
+				"debian:buster": {"debian:buster"},
+			},
+		},
+		{
+			name:               "happy path, move a tag",
+			method:             http.MethodPost,
+			path:               "/images/debian:buster/tag?repo=alpine",
+			expectedStatusCode: http.StatusCreated,
+			expectedRepoTags: map[string][]string{
+				"alpine:3.11":   {"alpine:3.11", "myalpine:v1"},
+				"alpine:latest": {"debian:buster", "alpine:latest"},
+			},
+		},
+		{
+			name:               "sad path, tag an unknown image",
+			method:             http.MethodPost,
+			path:               "/images/unknown:1.0/tag?repo=foo",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "sad path, invalid repository",
+			method:             http.MethodPost,
+			path:               "/images/alpine:3.11/tag?repo=Invalid",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "sad path, delete an image with several tags by ID",
+			method:             http.MethodDelete,
+			path:               "/images/" + alpineID.String(),
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "happy path, untag",
+			method:             http.MethodDelete,
+			path:               "/images/alpine:latest",
+			expectedStatusCode: http.StatusOK,
+			expectedDeleted:    []image.DeleteResponse{{Untagged: "alpine:latest"}},
+			expectedRepoTags: map[string][]string{
+				"debian:buster": {"debian:buster"},
+			},
+			expectedSavedTags: map[string][]string{
+				"alpine:3.11":   {"alpine:3.11"},
+				"myalpine:v1":   {"myalpine:v1"},
+				"debian:buster": {"debian:buster"},
+			},
+		},
+		{
+			name:               "sad path, delete an image in use",
+			method:             http.MethodDelete,
+			path:               "/images/debian:buster",
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:               "happy path, force deleting an image in use",
+			method:             http.MethodDelete,
+			path:               "/images/debian:buster?force=1",
+			expectedStatusCode: http.StatusOK,
+			expectedDeleted:    []image.DeleteResponse{{Untagged: "debian:buster"}, {Deleted: debianID.String()}},
+		},
+		{
+			name:               "happy path, force deleting by ID",
+			method:             http.MethodDelete,
+			path:               "/images/" + alpineID.String() + "?force=1&noprune=1",
+			expectedStatusCode: http.StatusOK,
+			expectedDeleted: []image.DeleteResponse{
+				{Untagged: "alpine:3.11"},
+				{Untagged: "myalpine:v1"},
+				{Deleted: alpineID.String()},
+			},
+		},
+		{
+			name:               "sad path, deleted image",
+			method:             http.MethodDelete,
+			path:               "/images/alpine:3.11",
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			req, err := http.NewRequest(step.method, ts.URL+"/v1.45"+step.path, nil)
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, step.expectedStatusCode, resp.StatusCode)
+
+			if step.expectedDeleted != nil {
+				var got []image.DeleteResponse
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+				assert.Equal(t, step.expectedDeleted, got)
+			}
+
+			for name, expected := range step.expectedRepoTags {
+				resp, err := http.Get(fmt.Sprintf("%s/v1.45/images/%s/json", ts.URL, name))
+				require.NoError(t, err)
+				var inspect image.InspectResponse
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&inspect))
+				resp.Body.Close()
+				assert.Equal(t, expected, inspect.RepoTags, name)
+			}
+
+			for name, expected := range step.expectedSavedTags {
+				resp, err := http.Get(fmt.Sprintf("%s/v1.45/images/%s/get", ts.URL, name))
+				require.NoError(t, err)
+				b, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				resp.Body.Close()
+
+				manifest, err := tarball.LoadManifest(func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(b)), nil
+				})
+				require.NoError(t, err)
+				require.Len(t, manifest, 1)
+				assert.Equal(t, expected, manifest[0].RepoTags, name)
+			}
+		})
+	}
+
+	code, got := getImagesJSON(t, ts.URL, "1.45", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Empty(t, got)
+}
@@ -0,0 +1,86 @@
+package system
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/server/httputils"
+	"github.com/docker/docker/api/server/router"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/errdefs"
+	"golang.org/x/xerrors"
+
+	"github.com/aquasecurity/testdocker/auth"
+)
+
+// authenticator verifies credentials and returns an identity token
+type authenticator interface {
+	Login(user, password, identityToken string) (string, error)
+}
+
+// systemRouter is a router to talk with the system controller
+type systemRouter struct {
+	routes    []router.Route
+	auth      authenticator
+	basicAuth bool
+}
+
+// NewRouter initializes a new system router.
+// Credentials are checked against the auth of the registry to log in to.
+// If basicAuth is true, the registry doesn't issue tokens and no identity token is returned.
+func NewRouter(a auth.Auth, basicAuth bool) router.Router {
+	r := &systemRouter{
+		auth:      auth.NewRouter(a),
+		basicAuth: basicAuth,
+	}
+	r.initRoutes()
+	return r
+}
+
+// Routes returns the available routes to the system controller
+func (s *systemRouter) Routes() []router.Route {
+	return s.routes
+}
+
+// initRoutes initializes the routes in the system router
+func (s *systemRouter) initRoutes() {
+	s.routes = []router.Route{
+		// POST
+		router.NewPostRoute("/auth", s.postAuth),
+	}
+}
+
+// ref. https://github.com/moby/moby/blob/4f0d95fa6ee7f865597c03b9e63702cdcb0f7067/api/server/router/system/system_routes.go#L378
+func (s *systemRouter) postAuth(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	var config registry.AuthConfig
+	err := json.NewDecoder(r.Body).Decode(&config)
+	r.Body.Close()
+	if err != nil {
+		return errdefs.InvalidParameter(err)
+	}
+
+	// Legacy clients send the credentials base64 encoded
+	if config.Username == "" && config.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(config.Auth)
+		if err != nil {
+			return errdefs.InvalidParameter(xerrors.Errorf("invalid auth: %w", err))
+		}
+		config.Username, config.Password, _ = strings.Cut(string(decoded), ":")
+	}
+
+	token, err := s.auth.Login(config.Username, config.Password, config.IdentityToken)
+	if err != nil {
+		return err
+	}
+	if s.basicAuth {
+		token = ""
+	}
+
+	return httputils.WriteJSON(w, http.StatusOK, &registry.AuthenticateOKBody{
+		Status:        "Login Succeeded",
+		IdentityToken: token,
+	})
+}
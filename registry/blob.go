@@ -0,0 +1,201 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/docker/errdefs"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// blob is the content of a blob stored in the registry
+type blob struct {
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+func newBlob(b []byte) blob {
+	return blob{
+		size: int64(len(b)),
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		},
+	}
+}
+
+// findBlob looks up the blob in the pushed blobs and the images of the repository
+func (s *registryRouter) findBlob(version, name string, h v1.Hash) (blob, error) {
+	s.mu.RLock()
+	b, ok := s.blobs[name][h]
+	_, deleted := s.deletedBlobs[name][h]
+	s.mu.RUnlock()
+	if ok {
+		return b, nil
+	} else if deleted {
+		return blob{}, v2.ErrorCodeBlobUnknown.WithDetail(h)
+	}
+
+	images, _, err := s.repositoryContents(version, name)
+	if err != nil {
+		return blob{}, err
+	}
+
+	for _, img := range images {
+		b, ok, err := imageBlob(img, h)
+		if err != nil {
+			return blob{}, err
+		} else if ok {
+			return b, nil
+		}
+	}
+
+	return blob{}, v2.ErrorCodeBlobUnknown.WithDetail(h)
+}
+
+// imageBlob returns the config file or the layer of the image
+func imageBlob(img v1.Image, h v1.Hash) (blob, bool, error) {
+	configName, err := img.ConfigName()
+	if err != nil {
+		return blob{}, false, errdefs.Unavailable(err)
+	}
+
+	if configName == h {
+		b, err := img.RawConfigFile()
+		if err != nil {
+			return blob{}, false, errdefs.Unavailable(err)
+		}
+		return newBlob(b), true, nil
+	}
+
+	l, err := img.LayerByDigest(h)
+	if err != nil {
+		return blob{}, false, nil // Not found
+	}
+
+	size, err := l.Size()
+	if err != nil {
+		return blob{}, false, errdefs.Unavailable(err)
+	}
+
+	return blob{
+		size: size,
+		open: l.Compressed,
+	}, true, nil
+}
+
+// serveBlob writes the blob with its metadata headers. Only the headers are written for HEAD requests.
+// A single byte range can be requested with the Range header.
+func serveBlob(w http.ResponseWriter, r *http.Request, b blob, h v1.Hash) error {
+	offset, length, partial, err := parseRange(r.Header.Get("Range"), b.size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", b.size))
+		return err
+	}
+
+	var rc io.ReadCloser
+	if r.Method != http.MethodHead {
+		if rc, err = b.open(); err != nil {
+			return errdefs.Unavailable(err)
+		}
+		defer rc.Close()
+
+		// Skip the bytes before the range since compressed layers can't be seeked
+		if _, err = io.CopyN(io.Discard, rc, offset); err != nil {
+			return errdefs.Unavailable(err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Docker-Content-Digest", h.String())
+	w.Header().Set("Accept-Ranges", "bytes")
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, b.size))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if rc == nil {
+		return nil
+	}
+	if _, err = io.CopyN(w, rc, length); err != nil {
+		return errdefs.Unavailable(err)
+	}
+	return nil
+}
+
+// parseRange parses the Range header and returns the offset and the length of the requested range.
+// It returns the whole content if the header is empty or requests multiple ranges.
+// ref. https://www.rfc-editor.org/rfc/rfc9110#name-range
+func parseRange(header string, size int64) (offset, length int64, partial bool, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, false, errCodeRangeInvalid.WithDetail(header)
+	}
+
+	switch {
+	case first == "":
+		// The last N bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, errCodeRangeInvalid.WithDetail(header)
+		}
+		n = min(n, size)
+		return size - n, n, true, nil
+	default:
+		offset, err = strconv.ParseInt(first, 10, 64)
+		if err != nil || offset < 0 || offset >= size {
+			return 0, 0, false, errCodeRangeInvalid.WithDetail(header)
+		}
+
+		end := size - 1
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < offset {
+				return 0, 0, false, errCodeRangeInvalid.WithDetail(header)
+			}
+			end = min(end, size-1)
+		}
+		return offset, end - offset + 1, true, nil
+	}
+}
+
+// ref. https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#deleting-blobs
+func (s *registryRouter) deleteBlobHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if !s.deleteEnabled {
+		return errcode.ErrorCodeUnsupported.WithMessage("deletion is disabled")
+	}
+
+	h, err := v1.NewHash(vars["digest"])
+	if err != nil {
+		return v2.ErrorCodeDigestInvalid.WithDetail(err.Error())
+	}
+
+	if _, err = s.findBlob(vars["version"], vars["name"], h); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.blobs[vars["name"]], h)
+	// The blob may belong to a preloaded image, which can't be modified
+	if s.deletedBlobs[vars["name"]] == nil {
+		s.deletedBlobs[vars["name"]] = map[v1.Hash]struct{}{}
+	}
+	s.deletedBlobs[vars["name"]][h] = struct{}{}
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
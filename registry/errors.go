@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/docker/api/server/httpstatus"
+)
+
+// errCodeRangeInvalid is returned when a chunk doesn't start where the previous one ended
+// or the requested range of a blob can't be satisfied.
+var errCodeRangeInvalid = errcode.Register("testdocker.registry", errcode.ErrorDescriptor{
+	Value:          "RANGE_INVALID",
+	Message:        "invalid content range",
+	HTTPStatusCode: http.StatusRequestedRangeNotSatisfiable,
+})
+
+// makeErrorHandler writes the error in the format of the distribution spec instead of the Docker Engine API.
+// ref. https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#error-codes
+func makeErrorHandler(err error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statusCode := httpstatus.FromError(err)
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(statusCode)
+		_ = json.NewEncoder(w).Encode(errcode.Errors{toErrorCoder(err, statusCode)})
+	}
+}
+
+// toErrorCoder returns the error as is if it has a distribution error code.
+// Otherwise, the error code is derived from the status code.
+func toErrorCoder(err error, statusCode int) error {
+	var coder interface {
+		error
+		errcode.ErrorCoder
+	}
+	if errors.As(err, &coder) {
+		return coder
+	}
+
+	code := errcode.ErrorCodeUnknown
+	switch statusCode {
+	case http.StatusUnauthorized:
+		code = errcode.ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		code = errcode.ErrorCodeDenied
+	case http.StatusMethodNotAllowed:
+		code = errcode.ErrorCodeUnsupported
+	case http.StatusTooManyRequests:
+		code = errcode.ErrorCodeTooManyRequests
+	case http.StatusServiceUnavailable:
+		code = errcode.ErrorCodeUnavailable
+	}
+	return code.WithDetail(err.Error())
+}
@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"github.com/docker/docker/errdefs"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// flattenIndex returns all the images and indexes referenced by the index, including nested ones
+func flattenIndex(idx v1.ImageIndex) ([]v1.Image, []v1.ImageIndex, error) {
+	m, err := idx.IndexManifest()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var images []v1.Image
+	var indexes []v1.ImageIndex
+	for _, desc := range m.Manifests {
+		switch {
+		case desc.MediaType.IsImage():
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				return nil, nil, err
+			}
+			images = append(images, img)
+		case desc.MediaType.IsIndex():
+			child, err := idx.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, nil, err
+			}
+			childImages, childIndexes, err := flattenIndex(child)
+			if err != nil {
+				return nil, nil, err
+			}
+			images = append(images, childImages...)
+			indexes = append(append(indexes, child), childIndexes...)
+		}
+	}
+	return images, indexes, nil
+}
+
+func indexManifest(idx v1.ImageIndex) (manifest, error) {
+	media, err := idx.MediaType()
+	if err != nil {
+		return manifest{}, errdefs.Unavailable(err)
+	}
+
+	b, err := idx.RawManifest()
+	if err != nil {
+		return manifest{}, errdefs.Unavailable(err)
+	}
+
+	return manifest{
+		mediaType: media,
+		body:      b,
+	}, nil
+}
@@ -0,0 +1,230 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/docker/errdefs"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// manifest is a manifest pushed to the registry
+type manifest struct {
+	mediaType types.MediaType
+	body      []byte
+}
+
+// findManifest looks up the manifest by tag or digest in the repository
+func (s *registryRouter) findManifest(version, name, reference string) (manifest, error) {
+	imageName := manifestKey(version, name, reference)
+
+	s.mu.RLock()
+	m, ok := s.manifests[imageName]
+	img, isImage := s.images[imageName]
+	idx, isIndex := s.indexes[imageName]
+	s.mu.RUnlock()
+	switch {
+	case ok:
+		return m, nil
+	case isImage:
+		return imageManifest(img)
+	case isIndex:
+		return indexManifest(idx)
+	}
+
+	// Images and indexes can be pulled by digest even if they are registered with a tag,
+	// and the children of indexes are resolvable only by digest.
+	if h, err := v1.NewHash(reference); err == nil {
+		images, indexes, err := s.repositoryContents(version, name)
+		if err != nil {
+			return manifest{}, err
+		}
+		for _, img := range images {
+			if d, err := img.Digest(); err == nil && d == h {
+				return imageManifest(img)
+			}
+		}
+		for _, idx := range indexes {
+			if d, err := idx.Digest(); err == nil && d == h {
+				return indexManifest(idx)
+			}
+		}
+	}
+
+	return manifest{}, v2.ErrorCodeManifestUnknown.WithDetail(imageName)
+}
+
+func imageManifest(img v1.Image) (manifest, error) {
+	media, err := img.MediaType()
+	if err != nil {
+		return manifest{}, errdefs.Unavailable(err)
+	}
+
+	b, err := img.RawManifest()
+	if err != nil {
+		return manifest{}, errdefs.Unavailable(err)
+	}
+
+	return manifest{
+		mediaType: media,
+		body:      b,
+	}, nil
+}
+
+// ref. https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#pushing-manifests
+func (s *registryRouter) putManifestHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return v2.ErrorCodeManifestInvalid.WithDetail(err.Error())
+	}
+
+	mediaType := types.MediaType(r.Header.Get("Content-Type"))
+	if mediaType == "" {
+		// Fall back to the media type in the manifest
+		var v struct {
+			MediaType types.MediaType `json:"mediaType"`
+		}
+		if err = json.Unmarshal(b, &v); err != nil {
+			return v2.ErrorCodeManifestInvalid.WithDetail(err.Error())
+		}
+		mediaType = v.MediaType
+	}
+
+	digest, _, err := v1.SHA256(bytes.NewReader(b))
+	if err != nil {
+		return errdefs.Unavailable(err)
+	}
+
+	reference := vars["reference"]
+	isDigest := strings.HasPrefix(reference, "sha256:")
+	if isDigest && reference != digest.String() {
+		return v2.ErrorCodeDigestInvalid.WithDetail(fmt.Sprintf("digest mismatch: expected %s, got %s", reference, digest))
+	}
+
+	if err = s.verifyReferences(vars, mediaType, b); err != nil {
+		return err
+	}
+
+	m := manifest{
+		mediaType: mediaType,
+		body:      b,
+	}
+
+	s.mu.Lock()
+	s.manifests[manifestKey(vars["version"], vars["name"], digest.String())] = m
+	if !isDigest {
+		s.manifests[manifestKey(vars["version"], vars["name"], reference)] = m
+	}
+	s.mu.Unlock()
+
+	// Let the client know the referrers API has indexed the subject
+	if subject, ok := m.subject(); ok && !s.referrersDisabled {
+		w.Header().Set("OCI-Subject", subject.String())
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v%s/%s/manifests/%s", vars["version"], vars["name"], digest))
+	w.Header().Set("Docker-Content-Digest", digest.String())
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// ref. https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#deleting-manifests
+func (s *registryRouter) deleteManifestHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if !s.deleteEnabled {
+		return errcode.ErrorCodeUnsupported.WithMessage("deletion is disabled")
+	}
+
+	reference := vars["reference"]
+	if _, err := s.findManifest(vars["version"], vars["name"], reference); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Deleting a tag removes only the tag
+	if !strings.HasPrefix(reference, "sha256:") {
+		key := manifestKey(vars["version"], vars["name"], reference)
+		delete(s.manifests, key)
+		delete(s.images, key)
+		delete(s.indexes, key)
+		w.WriteHeader(http.StatusAccepted)
+		return nil
+	}
+
+	// Deleting a digest removes all the tags pointing to the manifest
+	prefix := fmt.Sprintf("v%s/", vars["version"])
+	for key, m := range s.manifests {
+		if repo, _ := repositoryName(key, prefix); repo != vars["name"] {
+			continue
+		}
+		if d, _, err := v1.SHA256(bytes.NewReader(m.body)); err == nil && d.String() == reference {
+			delete(s.manifests, key)
+		}
+	}
+	for key, img := range s.images {
+		if repo, _ := repositoryName(key, prefix); repo != vars["name"] {
+			continue
+		}
+		if d, err := img.Digest(); err == nil && d.String() == reference {
+			delete(s.images, key)
+		}
+	}
+	for key, idx := range s.indexes {
+		if repo, _ := repositoryName(key, prefix); repo != vars["name"] {
+			continue
+		}
+		if d, err := idx.Digest(); err == nil && d.String() == reference {
+			delete(s.indexes, key)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// verifyReferences checks that all the blobs and manifests referenced by the manifest exist in the repository
+func (s *registryRouter) verifyReferences(vars map[string]string, mediaType types.MediaType, b []byte) error {
+	switch {
+	case mediaType.IsImage():
+		m, err := v1.ParseManifest(bytes.NewReader(b))
+		if err != nil {
+			return v2.ErrorCodeManifestInvalid.WithDetail(err.Error())
+		}
+		for _, desc := range append([]v1.Descriptor{m.Config}, m.Layers...) {
+			if _, err = s.findBlob(vars["version"], vars["name"], desc.Digest); err != nil {
+				return v2.ErrorCodeManifestBlobUnknown.WithDetail(desc.Digest)
+			}
+		}
+	case mediaType.IsIndex():
+		m, err := v1.ParseIndexManifest(bytes.NewReader(b))
+		if err != nil {
+			return v2.ErrorCodeManifestInvalid.WithDetail(err.Error())
+		}
+		for _, desc := range m.Manifests {
+			if _, err = s.findManifest(vars["version"], vars["name"], desc.Digest.String()); err != nil {
+				return v2.ErrorCodeManifestBlobUnknown.WithDetail(desc.Digest)
+			}
+		}
+	default:
+		return v2.ErrorCodeManifestInvalid.WithDetail(fmt.Sprintf("unsupported manifest media type: %s", mediaType))
+	}
+	return nil
+}
+
+// manifestKey returns the key of the manifest in the same format as Option.Images
+func manifestKey(version, name, reference string) string {
+	if strings.HasPrefix(reference, "sha256:") {
+		return fmt.Sprintf("v%s/%s@%s", version, name, reference)
+	}
+	return fmt.Sprintf("v%s/%s:%s", version, name, reference)
+}
@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	v2 "github.com/docker/distribution/registry/api/v2"
+)
+
+// paginate sorts the entries and returns the page selected by the "n" and "last" query parameters.
+// If more entries follow the page, the RFC 5988 Link header pointing to the next page is set on w.
+// ref. https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#listing-tags
+func paginate(w http.ResponseWriter, r *http.Request, entries []string) ([]string, error) {
+	sort.Strings(entries)
+
+	query := r.URL.Query()
+	if last := query.Get("last"); last != "" {
+		i := sort.SearchStrings(entries, last)
+		if i < len(entries) && entries[i] == last {
+			i++
+		}
+		entries = entries[i:]
+	}
+
+	if query.Get("n") == "" {
+		return entries, nil
+	}
+
+	n, err := strconv.Atoi(query.Get("n"))
+	if err != nil || n < 0 {
+		return nil, v2.ErrorCodePaginationNumberInvalid.WithDetail(query.Get("n"))
+	}
+
+	if n >= len(entries) {
+		return entries, nil
+	}
+	entries = entries[:n]
+
+	if n > 0 {
+		next := url.URL{
+			Path: r.URL.Path,
+			RawQuery: url.Values{
+				"n":    []string{strconv.Itoa(n)},
+				"last": []string{entries[n-1]},
+			}.Encode(),
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,149 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/docker/errdefs"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"golang.org/x/xerrors"
+)
+
+// manifestFields is the subset of the manifest fields needed to list referrers
+type manifestFields struct {
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Config       *v1.Descriptor    `json:"config,omitempty"`
+	Subject      *v1.Descriptor    `json:"subject,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// ref. https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#listing-referrers
+func (s *registryRouter) referrersHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if s.referrersDisabled {
+		// Clients fall back to the referrers tag schema on 404
+		return errdefs.NotFound(xerrors.New("referrers API is disabled"))
+	}
+
+	subject, err := v1.NewHash(vars["digest"])
+	if err != nil {
+		return v2.ErrorCodeDigestInvalid.WithDetail(err.Error())
+	}
+
+	manifests, err := s.repositoryManifests(vars["version"], vars["name"])
+	if err != nil {
+		return err
+	}
+
+	artifactType := r.URL.Query().Get("artifactType")
+
+	referrers := []v1.Descriptor{}
+	for _, m := range manifests {
+		var fields manifestFields
+		if err = json.Unmarshal(m.body, &fields); err != nil {
+			continue // Not a JSON manifest
+		}
+		if fields.Subject == nil || fields.Subject.Digest != subject {
+			continue
+		}
+
+		desc, err := m.descriptor()
+		if err != nil {
+			return errdefs.Unavailable(err)
+		}
+		desc.ArtifactType = fields.ArtifactType
+		if desc.ArtifactType == "" && fields.Config != nil {
+			desc.ArtifactType = string(fields.Config.MediaType)
+		}
+		desc.Annotations = fields.Annotations
+
+		if artifactType != "" && desc.ArtifactType != artifactType {
+			continue
+		}
+		referrers = append(referrers, desc)
+	}
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	// httputils.WriteJSON would overwrite the media type with application/json
+	w.Header().Set("Content-Type", string(types.OCIImageIndex))
+	w.WriteHeader(http.StatusOK)
+
+	return json.NewEncoder(w).Encode(v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Manifests:     referrers,
+	})
+}
+
+// repositoryManifests returns all the manifests in the repository, including the children of indexes
+func (s *registryRouter) repositoryManifests(version, name string) ([]manifest, error) {
+	prefix := fmt.Sprintf("v%s/", version)
+
+	var manifests []manifest
+	s.mu.RLock()
+	for key, m := range s.manifests {
+		if repo, _ := repositoryName(key, prefix); repo == name {
+			manifests = append(manifests, m)
+		}
+	}
+	s.mu.RUnlock()
+
+	images, indexes, err := s.repositoryContents(version, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range images {
+		m, err := imageManifest(img)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+	for _, idx := range indexes {
+		m, err := indexManifest(idx)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+
+	// The same manifest can be registered with several tags
+	seen := map[string]struct{}{}
+	var unique []manifest
+	for _, m := range manifests {
+		if _, ok := seen[string(m.body)]; ok {
+			continue
+		}
+		seen[string(m.body)] = struct{}{}
+		unique = append(unique, m)
+	}
+	return unique, nil
+}
+
+// descriptor returns the descriptor pointing to the manifest
+func (m manifest) descriptor() (v1.Descriptor, error) {
+	digest, size, err := v1.SHA256(bytes.NewReader(m.body))
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	return v1.Descriptor{
+		MediaType: m.mediaType,
+		Digest:    digest,
+		Size:      size,
+	}, nil
+}
+
+// subject returns the digest of the subject of the manifest if any
+func (m manifest) subject() (v1.Hash, bool) {
+	var fields manifestFields
+	if err := json.Unmarshal(m.body, &fields); err != nil || fields.Subject == nil {
+		return v1.Hash{}, false
+	}
+	return fields.Subject.Digest, true
+}
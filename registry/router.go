@@ -1,28 +1,75 @@
 package registry
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/docker/api/server/httputils"
 	"github.com/docker/docker/api/server/router"
 	"github.com/docker/docker/errdefs"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"golang.org/x/xerrors"
+
+	"github.com/aquasecurity/testdocker/auth"
 )
 
 // registryRouter is a router to talk with the image controller
 type registryRouter struct {
-	routes []router.Route
-	images map[string]v1.Image
+	routes            []router.Route
+	deleteEnabled     bool
+	referrersDisabled bool
+
+	mu           sync.RWMutex
+	images       map[string]v1.Image
+	indexes      map[string]v1.ImageIndex
+	blobs        map[string]map[v1.Hash]blob     // pushed blobs per repository
+	deletedBlobs map[string]map[v1.Hash]struct{} // deleted blobs of the preloaded images per repository
+	uploads      map[string]*upload              // upload sessions keyed by UUID
+	manifests    map[string]manifest             // pushed manifests keyed in the same format as images
+}
+
+// ref. https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#listing-tags
+type tagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// ref. https://distribution.github.io/distribution/spec/api/#listing-repositories
+type catalog struct {
+	Repositories []string `json:"repositories"`
 }
 
 // NewRouter initializes a new image router
 func NewRouter(images map[string]v1.Image) router.Router {
+	return newRouter(Option{Images: images})
+}
+
+func newRouter(option Option) *registryRouter {
+	images := map[string]v1.Image{}
+	for name, img := range option.Images {
+		images[name] = img
+	}
+
+	indexes := map[string]v1.ImageIndex{}
+	for name, idx := range option.Indexes {
+		indexes[name] = idx
+	}
+
 	r := &registryRouter{
-		images: images,
+		deleteEnabled:     option.DeleteEnabled,
+		referrersDisabled: option.DisableReferrers,
+		images:            images,
+		indexes:           indexes,
+		blobs:             map[string]map[v1.Hash]blob{},
+		deletedBlobs:      map[string]map[v1.Hash]struct{}{},
+		uploads:           map[string]*upload{},
+		manifests:         map[string]manifest{},
 	}
 	r.initRoutes()
 	return r
@@ -38,8 +85,31 @@ func (s *registryRouter) initRoutes() {
 	s.routes = []router.Route{
 		// GET
 		router.NewGetRoute("/", s.pingHandler),
+		router.NewGetRoute("/_catalog", s.catalogHandler),
 		router.NewGetRoute("/{name:.*}/manifests/{reference}", s.manifestHandler),
 		router.NewGetRoute("/{name:.*}/blobs/{digest}", s.blobHandler),
+		router.NewGetRoute("/{name:.*}/tags/list", s.tagsHandler),
+		router.NewGetRoute("/{name:.*}/referrers/{digest}", s.referrersHandler),
+		router.NewGetRoute("/{name:.*}/blobs/uploads/{uuid}", s.uploadStatusHandler),
+
+		// HEAD
+		router.NewHeadRoute("/{name:.*}/manifests/{reference}", s.manifestHandler),
+		router.NewHeadRoute("/{name:.*}/blobs/{digest}", s.blobHandler),
+
+		// POST
+		router.NewPostRoute("/{name:.*}/blobs/uploads/", s.startUploadHandler),
+
+		// PATCH
+		router.NewRoute(http.MethodPatch, "/{name:.*}/blobs/uploads/{uuid}", s.patchUploadHandler),
+
+		// PUT
+		router.NewPutRoute("/{name:.*}/manifests/{reference}", s.putManifestHandler),
+		router.NewPutRoute("/{name:.*}/blobs/uploads/{uuid}", s.putUploadHandler),
+
+		// DELETE
+		router.NewDeleteRoute("/{name:.*}/manifests/{reference}", s.deleteManifestHandler),
+		router.NewDeleteRoute("/{name:.*}/blobs/{digest}", s.deleteBlobHandler),
+		router.NewDeleteRoute("/{name:.*}/blobs/uploads/{uuid}", s.cancelUploadHandler),
 	}
 }
 
@@ -53,80 +123,168 @@ func (s *registryRouter) pingHandler(ctx context.Context, w http.ResponseWriter,
 }
 
 func (s *registryRouter) manifestHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
-	imageName := fmt.Sprintf("v%s/%s:%s", vars["version"], vars["name"], vars["reference"])
-	if strings.HasPrefix(vars["reference"], "sha256:") {
-		imageName = fmt.Sprintf("v%s/%s@%s", vars["version"], vars["name"], vars["reference"])
-	}
-	img, ok := s.images[imageName]
-	if !ok {
-		return errdefs.NotFound(xerrors.Errorf("unknown image: %s", imageName))
+	m, err := s.findManifest(vars["version"], vars["name"], vars["reference"])
+	if err != nil {
+		return err
 	}
 
-	media, err := img.MediaType()
+	digest, _, err := v1.SHA256(bytes.NewReader(m.body))
 	if err != nil {
 		return errdefs.Unavailable(err)
 	}
 
-	w.Header().Set("Content-Type", string(media))
+	w.Header().Set("Content-Type", string(m.mediaType))
+	w.Header().Set("Content-Length", strconv.Itoa(len(m.body)))
+	w.Header().Set("Docker-Content-Digest", digest.String())
 	w.WriteHeader(http.StatusOK)
 
-	b, err := img.RawManifest()
-	if err != nil {
-		return errdefs.Unavailable(err)
+	if r.Method == http.MethodHead {
+		return nil
 	}
-	if _, err = w.Write(b); err != nil {
+	if _, err = w.Write(m.body); err != nil {
 		return errdefs.Unavailable(err)
 	}
 	return nil
 }
 
 func (s *registryRouter) blobHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
-	imageName := fmt.Sprintf("v%s/%s", vars["version"], vars["name"])
-	for name, img := range s.images {
-		if !strings.HasPrefix(name, imageName) {
-			continue
-		}
+	h, err := v1.NewHash(vars["digest"])
+	if err != nil {
+		// An invalid digest can never match a blob
+		return v2.ErrorCodeBlobUnknown.WithDetail(vars["digest"])
+	}
 
-		h, err := v1.NewHash(vars["digest"])
-		if err != nil {
-			return errdefs.InvalidParameter(err)
-		}
+	b, err := s.findBlob(vars["version"], vars["name"], h)
+	if err != nil {
+		return err
+	}
 
-		// return the config file
-		configName, err := img.ConfigName()
-		if err != nil {
-			return errdefs.Unavailable(err)
+	return serveBlob(w, r, b, h)
+}
+
+// ref. https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#listing-tags
+func (s *registryRouter) tagsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	prefix := fmt.Sprintf("v%s/%s:", vars["version"], vars["name"])
+
+	var tags []string
+	for _, name := range s.imageNames() {
+		if tag, ok := strings.CutPrefix(name, prefix); ok {
+			tags = append(tags, tag)
 		}
+	}
+
+	if len(tags) == 0 {
+		return v2.ErrorCodeNameUnknown.WithDetail(vars["name"])
+	}
 
-		if configName == h {
-			b, err := img.RawConfigFile()
-			if err != nil {
-				return errdefs.Unavailable(err)
-			}
-			w.Header().Set("Content-Type", "application/octet-stream")
-			_, err = w.Write(b)
-			return errdefs.Unavailable(err)
+	tags, err := paginate(w, r, tags)
+	if err != nil {
+		return err
+	}
+
+	return httputils.WriteJSON(w, http.StatusOK, tagList{
+		Name: vars["name"],
+		Tags: tags,
+	})
+}
+
+// ref. https://distribution.github.io/distribution/spec/api/#listing-repositories
+func (s *registryRouter) catalogHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	prefix := fmt.Sprintf("v%s/", vars["version"])
+
+	seen := map[string]struct{}{}
+	repositories := []string{}
+	for _, name := range s.imageNames() {
+		repo, ok := repositoryName(name, prefix)
+		if !ok {
+			continue
 		}
+		if _, ok = seen[repo]; ok {
+			continue
+		}
+		seen[repo] = struct{}{}
 
-		// return the layer content
-		l, err := img.LayerByDigest(h)
-		if err != nil {
-			continue // Not found, try the next image
+		// Hide repositories the token doesn't grant access to
+		if !auth.Allowed(ctx, repo, auth.ActionPull) {
+			continue
 		}
+		repositories = append(repositories, repo)
+	}
 
-		rc, err := l.Compressed()
-		if err != nil {
-			return errdefs.Unavailable(err)
+	repositories, err := paginate(w, r, repositories)
+	if err != nil {
+		return err
+	}
+
+	return httputils.WriteJSON(w, http.StatusOK, catalog{
+		Repositories: repositories,
+	})
+}
+
+// imageNames returns the keys of the preloaded images and indexes and the pushed manifests
+func (s *registryRouter) imageNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := map[string]struct{}{}
+	for name := range s.images {
+		seen[name] = struct{}{}
+	}
+	for name := range s.indexes {
+		seen[name] = struct{}{}
+	}
+	for name := range s.manifests {
+		seen[name] = struct{}{}
+	}
+
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	return names
+}
+
+// repositoryContents returns the preloaded images and indexes of the repository,
+// including the images and indexes referenced by the indexes
+func (s *registryRouter) repositoryContents(version, name string) ([]v1.Image, []v1.ImageIndex, error) {
+	prefix := fmt.Sprintf("v%s/", version)
+
+	var images []v1.Image
+	var roots []v1.ImageIndex
+
+	s.mu.RLock()
+	for key, img := range s.images {
+		if repo, _ := repositoryName(key, prefix); repo == name {
+			images = append(images, img)
 		}
+	}
+	for key, idx := range s.indexes {
+		if repo, _ := repositoryName(key, prefix); repo == name {
+			roots = append(roots, idx)
+		}
+	}
+	s.mu.RUnlock()
 
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.Header().Set("Docker-Content-Digest", vars["digest"])
-		w.WriteHeader(http.StatusOK)
-		if _, err = io.Copy(w, rc); err != nil {
-			return errdefs.Unavailable(err)
+	indexes := roots
+	for _, idx := range roots {
+		childImages, childIndexes, err := flattenIndex(idx)
+		if err != nil {
+			return nil, nil, errdefs.Unavailable(err)
 		}
-		return nil
+		images = append(images, childImages...)
+		indexes = append(indexes, childIndexes...)
 	}
+	return images, indexes, nil
+}
 
-	return errdefs.NotFound(xerrors.Errorf("unknown image: %s", imageName))
+// repositoryName returns the repository name of an image key such as "v2/library/alpine:3.10".
+func repositoryName(imageName, prefix string) (string, bool) {
+	name, ok := strings.CutPrefix(imageName, prefix)
+	if !ok {
+		return "", false
+	}
+	if i := strings.IndexAny(name, ":@"); i >= 0 {
+		name = name[:i]
+	}
+	return name, name != ""
 }
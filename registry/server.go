@@ -12,19 +12,38 @@ import (
 
 type Option struct {
 	Images map[string]v1.Image
-	Auth   auth.Auth
+	// Indexes are served in the same way as Images. The manifests referenced by an index are resolvable by digest.
+	Indexes map[string]v1.ImageIndex
+	Auth    auth.Auth
+
+	// DeleteEnabled allows manifests and blobs to be deleted.
+	// If false, DELETE requests are rejected with UNSUPPORTED as distribution does by default.
+	DeleteEnabled bool
+
+	// BasicAuth makes the registry authenticate each request with the HTTP Basic credentials of Auth
+	// and challenge with "Basic" instead of issuing bearer tokens.
+	BasicAuth bool
+
+	// DisableReferrers makes the referrers API respond with 404 so that clients fall back to the referrers tag schema.
+	DisableReferrers bool
 }
 
 func NewDockerRegistry(option Option) *httptest.Server {
 	var routes []router.Router
-	routes = append(routes, NewRouter(option.Images))
+	routes = append(routes, newRouter(option))
 
 	a := auth.NewRouter(option.Auth)
-	routes = append(routes, a)
+	if option.Auth.Realm == "" && !option.BasicAuth {
+		// Tokens are issued by the registry itself
+		routes = append(routes, a)
+	}
 
-	m := server.CreateMux(routes)
+	m := server.CreateMuxWithErrorHandler(routes, makeErrorHandler)
 
-	if option.Auth.IsValid() {
+	switch {
+	case option.BasicAuth:
+		m.Use(a.BasicMiddleware)
+	case option.Auth.IsValid():
 		// Authentication
 		m.Use(a.Middleware)
 	}
@@ -0,0 +1,236 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/docker/errdefs"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/aquasecurity/testdocker/auth"
+)
+
+// upload is an upload session in progress
+type upload struct {
+	name string
+	buf  bytes.Buffer
+}
+
+// ref. https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#pushing-blobs
+func (s *registryRouter) startUploadHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	// Monolithic upload in a single POST request
+	if digest := r.URL.Query().Get("digest"); digest != "" {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return v2.ErrorCodeBlobUploadInvalid.WithDetail(err.Error())
+		}
+		return s.commitBlob(w, vars, digest, b)
+	}
+
+	// Cross-repository blob mount. If the blob can't be mounted, an upload session is started instead.
+	// ref. https://github.com/opencontainers/distribution-spec/blob/v1.1.0/spec.md#mounting-a-blob-from-another-repository
+	if mount, from := r.URL.Query().Get("mount"), r.URL.Query().Get("from"); mount != "" && from != "" {
+		if mounted, err := s.mountBlob(ctx, w, vars, mount, from); err != nil {
+			return err
+		} else if mounted {
+			return nil
+		}
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return errdefs.Unavailable(err)
+	}
+
+	s.mu.Lock()
+	s.uploads[id] = &upload{name: vars["name"]}
+	s.mu.Unlock()
+
+	writeUploadHeaders(w, vars, id, 0)
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+func (s *registryRouter) patchUploadHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	chunk, err := io.ReadAll(r.Body)
+	if err != nil {
+		return v2.ErrorCodeBlobUploadInvalid.WithDetail(err.Error())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.upload(vars)
+	if err != nil {
+		return err
+	}
+
+	// Chunks must be uploaded in order
+	if contentRange := r.Header.Get("Content-Range"); contentRange != "" {
+		start, _, ok := strings.Cut(contentRange, "-")
+		offset, err := strconv.ParseInt(start, 10, 64)
+		if !ok || err != nil || offset != int64(u.buf.Len()) {
+			return errCodeRangeInvalid.WithDetail(fmt.Sprintf("expected the chunk to start at %d: %s", u.buf.Len(), contentRange))
+		}
+	}
+	u.buf.Write(chunk)
+
+	writeUploadHeaders(w, vars, vars["uuid"], u.buf.Len())
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+func (s *registryRouter) uploadStatusHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, err := s.upload(vars)
+	if err != nil {
+		return err
+	}
+
+	writeUploadHeaders(w, vars, vars["uuid"], u.buf.Len())
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *registryRouter) putUploadHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	// The last chunk can be sent with the closing request
+	chunk, err := io.ReadAll(r.Body)
+	if err != nil {
+		return v2.ErrorCodeBlobUploadInvalid.WithDetail(err.Error())
+	}
+
+	s.mu.Lock()
+	u, err := s.upload(vars)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	u.buf.Write(chunk)
+	b := bytes.Clone(u.buf.Bytes())
+	s.mu.Unlock()
+
+	if err = s.commitBlob(w, vars, r.URL.Query().Get("digest"), b); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.uploads, vars["uuid"])
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *registryRouter) cancelUploadHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.upload(vars); err != nil {
+		return err
+	}
+	delete(s.uploads, vars["uuid"])
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// mountBlob links the blob in the source repository to the repository of the request.
+// It reports false if the blob doesn't exist in the source repository or the token doesn't grant pull access to it.
+func (s *registryRouter) mountBlob(ctx context.Context, w http.ResponseWriter, vars map[string]string, digest, from string) (bool, error) {
+	h, err := v1.NewHash(digest)
+	if err != nil {
+		return false, v2.ErrorCodeDigestInvalid.WithDetail(err.Error())
+	}
+
+	if !auth.Allowed(ctx, from, auth.ActionPull) {
+		return false, nil
+	}
+
+	b, err := s.findBlob(vars["version"], from, h)
+	if err != nil {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	if s.blobs[vars["name"]] == nil {
+		s.blobs[vars["name"]] = map[v1.Hash]blob{}
+	}
+	s.blobs[vars["name"]][h] = b
+	delete(s.deletedBlobs[vars["name"]], h)
+	s.mu.Unlock()
+
+	w.Header().Set("Location", fmt.Sprintf("/v%s/%s/blobs/%s", vars["version"], vars["name"], h))
+	w.Header().Set("Docker-Content-Digest", h.String())
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+	return true, nil
+}
+
+// upload returns the upload session of the request. The caller must hold s.mu.
+func (s *registryRouter) upload(vars map[string]string) (*upload, error) {
+	u, ok := s.uploads[vars["uuid"]]
+	if !ok || u.name != vars["name"] {
+		return nil, v2.ErrorCodeBlobUploadUnknown.WithDetail(vars["uuid"])
+	}
+	return u, nil
+}
+
+// commitBlob verifies the digest of the uploaded content and stores it in the repository
+func (s *registryRouter) commitBlob(w http.ResponseWriter, vars map[string]string, digest string, b []byte) error {
+	expected, err := v1.NewHash(digest)
+	if err != nil {
+		return v2.ErrorCodeDigestInvalid.WithDetail(err.Error())
+	}
+
+	actual, _, err := v1.SHA256(bytes.NewReader(b))
+	if err != nil {
+		return errdefs.Unavailable(err)
+	}
+	if actual != expected {
+		return v2.ErrorCodeDigestInvalid.WithDetail(fmt.Sprintf("digest mismatch: expected %s, got %s", expected, actual))
+	}
+
+	s.mu.Lock()
+	if s.blobs[vars["name"]] == nil {
+		s.blobs[vars["name"]] = map[v1.Hash]blob{}
+	}
+	s.blobs[vars["name"]][actual] = newBlob(b)
+	delete(s.deletedBlobs[vars["name"]], actual)
+	s.mu.Unlock()
+
+	w.Header().Set("Location", fmt.Sprintf("/v%s/%s/blobs/%s", vars["version"], vars["name"], actual))
+	w.Header().Set("Docker-Content-Digest", actual.String())
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// writeUploadHeaders sets the headers describing the progress of the upload session
+func writeUploadHeaders(w http.ResponseWriter, vars map[string]string, id string, size int) {
+	end := size
+	if end > 0 {
+		end--
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v%s/%s/blobs/uploads/%s", vars["version"], vars["name"], id))
+	w.Header().Set("Range", fmt.Sprintf("0-%d", end))
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Content-Length", "0")
+}
+
+// newUUID generates a random (version 4) UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
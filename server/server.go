@@ -1,7 +1,6 @@
 package server
 
 import (
-	"context"
 	"net/http"
 
 	"github.com/docker/docker/api/server/httpstatus"
@@ -15,15 +14,18 @@ import (
 
 const versionMatcher = "/v{version:[0-9.]+}"
 
-func makeHTTPHandler(handler httputils.APIFunc) http.HandlerFunc {
+// ErrorHandler returns the handler writing the response for the error returned by an API function
+type ErrorHandler func(err error) http.HandlerFunc
+
+func makeHTTPHandler(handler httputils.APIFunc, errorHandler ErrorHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		if vars == nil {
 			vars = make(map[string]string)
 		}
 
-		if err := handler(context.Background(), w, r, vars); err != nil {
-			makeErrorHandler(err)(w, r)
+		if err := handler(r.Context(), w, r, vars); err != nil {
+			errorHandler(err)(w, r)
 		}
 	}
 }
@@ -50,11 +52,17 @@ func apiVersionSupportsJSONErrors(version string) bool {
 
 // https://github.com/moby/moby/blob/fdf7f4d4ea38e0af3967668c5e2fd06046b8bead/api/server/server.go#L166
 func CreateMux(routes []router.Router) *mux.Router {
+	return CreateMuxWithErrorHandler(routes, makeErrorHandler)
+}
+
+// CreateMuxWithErrorHandler is like CreateMux, but writes error responses with the given handler
+// instead of the Docker Engine API format.
+func CreateMuxWithErrorHandler(routes []router.Router, errorHandler ErrorHandler) *mux.Router {
 	// https://github.com/moby/moby/blob/fdf7f4d4ea38e0af3967668c5e2fd06046b8bead/api/server/server.go#L166
 	m := mux.NewRouter()
 	for _, route := range routes {
 		for _, r := range route.Routes() {
-			f := makeHTTPHandler(r.Handler())
+			f := makeHTTPHandler(r.Handler(), errorHandler)
 			m.Path(versionMatcher + r.Path()).Methods(r.Method()).Handler(f)
 			m.Path(r.Path()).Methods(r.Method()).Handler(f)
 		}
@@ -57,9 +57,9 @@ func ExtractFileFromTar(r io.Reader, filePath string) ([]byte, error) {
 		}
 
 		if hdr.Name == filePath {
+			// A single Read may return a part of the file, e.g. from a gzip stream
 			data := make([]byte, hdr.Size)
-			_, err := tf.Read(data)
-			if err != nil && err != io.EOF {
+			if _, err := io.ReadFull(tf, data); err != nil {
 				return nil, fmt.Errorf("unable to read file: %s", err)
 			}
 			return data, nil